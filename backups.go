@@ -0,0 +1,447 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/retrixe/octyne/system"
+)
+
+var errBackupNotFound = errors.New("this backup does not exist")
+var errBackupInProgress = errors.New("a backup or restore of this server is already in progress")
+var errServerRunning = errors.New("the server must be stopped first")
+
+// backupTimeLayout is the layout of backup file names, which are always in UTC.
+const backupTimeLayout = "2006-01-02T15-04-05Z"
+
+// backupFormats maps supported backup formats to their archive file extensions.
+var backupFormats = map[string]string{
+	"zip":     ".zip",
+	"tar":     ".tar",
+	"tar.gz":  ".tar.gz",
+	"tar.xz":  ".tar.xz",
+	"tar.zst": ".tar.zst",
+}
+
+// backupsInProgress contains the names of servers with an ongoing backup or restore.
+var backupsInProgress = xsync.NewMapOf[string, bool]()
+
+// BackupInfo is a backup archive created from a backup definition.
+type BackupInfo struct {
+	File string `json:"file"`
+	Size int64  `json:"size"`
+	Time int64  `json:"time"`
+}
+
+// backupPaths returns the slash-separated paths to back up, relative to the server directory.
+func (c *BackupJobConfig) backupPaths() []string {
+	if len(c.Paths) == 0 {
+		return []string{"."}
+	}
+	paths := make([]string, 0, len(c.Paths))
+	for _, file := range c.Paths {
+		file = strings.TrimLeft(path.Clean("/"+file), "/")
+		if file == "" {
+			file = "."
+		}
+		paths = append(paths, file)
+	}
+	return paths
+}
+
+// excludeFilter returns a filter for the archive functions in the system package, which rejects
+// Octyne's temporary folders and files matching any exclude pattern. Patterns containing a slash
+// are matched against the path relative to the server directory, others against the file name.
+func (c *BackupJobConfig) excludeFilter() func(string) bool {
+	return func(file string) bool {
+		if strings.HasPrefix(path.Base(file), ".octyne-") {
+			return false
+		}
+		for _, pattern := range c.Exclude {
+			var matched bool
+			if strings.Contains(strings.Trim(pattern, "/"), "/") {
+				matched, _ = path.Match(strings.Trim(pattern, "/"), file)
+			} else {
+				matched, _ = path.Match(strings.Trim(pattern, "/"), path.Base(file))
+			}
+			if matched {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// commandDelay returns how long to wait after sending pre-backup commands, defaulting to 5s.
+func (c *BackupJobConfig) commandDelay() time.Duration {
+	delay, err := time.ParseDuration(c.CommandDelay)
+	if err != nil {
+		return 5 * time.Second
+	}
+	return delay
+}
+
+// extension returns the archive file extension for the backup format, defaulting to tar.gz.
+func (c *BackupConfig) extension() (string, error) {
+	if c.Format == "" {
+		return ".tar.gz", nil
+	} else if ext, ok := backupFormats[c.Format]; ok {
+		return ext, nil
+	}
+	return "", errors.New("invalid backup format: " + c.Format)
+}
+
+// expired returns the indices of the backups which should be deleted according to the retention
+// rules, given the times they were created at, sorted from newest to oldest.
+func (r BackupRetentionConfig) expired(times []time.Time) []int {
+	if r.Last <= 0 && r.Daily <= 0 && r.Weekly <= 0 {
+		return nil
+	}
+	expired := make([]int, 0)
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	for index, t := range times {
+		keep := index < r.Last
+		day := t.Local().Format("2006-01-02")
+		if !days[day] && len(days) < r.Daily {
+			days[day] = true
+			keep = true
+		}
+		year, week := t.Local().ISOWeek()
+		if key := fmt.Sprint(year, "-", week); !weeks[key] && len(weeks) < r.Weekly {
+			weeks[key] = true
+			keep = true
+		}
+		if !keep {
+			expired = append(expired, index)
+		}
+	}
+	return expired
+}
+
+// backupDirectory returns the folder containing backups created from a backup definition,
+// ensuring that it is located outside the server directory.
+func backupDirectory(serverDir string, server string, name string, config BackupConfig) (string, error) {
+	if config.Destination == "" {
+		return "", errors.New("no destination is configured for this backup")
+	}
+	dir, err := filepath.Abs(filepath.Join(config.Destination, server, name))
+	if err != nil {
+		return "", err
+	}
+	serverDir, err = filepath.Abs(serverDir)
+	if err != nil {
+		return "", err
+	} else if filepathHasPrefix(dir, serverDir) {
+		return "", errors.New("the backup destination must be outside the server directory")
+	}
+	return dir, nil
+}
+
+// listBackups lists the backups in a backup folder, sorted from newest to oldest.
+func listBackups(dir string) ([]BackupInfo, error) {
+	backups := make([]BackupInfo, 0)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return backups, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		created, err := time.Parse(backupTimeLayout, strings.SplitN(entry.Name(), ".", 2)[0])
+		if err != nil {
+			continue // Not a backup created by Octyne.
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, BackupInfo{File: entry.Name(), Size: info.Size(), Time: created.Unix()})
+	}
+	slices.SortFunc(backups, func(a, b BackupInfo) int { return int(b.Time - a.Time) })
+	return backups, nil
+}
+
+// getBackupConfig returns a backup definition of the server along with its backup folder.
+func (process *Process) getBackupConfig(name string) (BackupConfig, string, error) {
+	process.ServerConfigMutex.RLock()
+	defer process.ServerConfigMutex.RUnlock()
+	config, ok := process.Backups[name]
+	if !ok {
+		return config, "", errBackupNotFound
+	}
+	dir, err := backupDirectory(process.Directory, process.Name, name, config)
+	return config, dir, err
+}
+
+// sendBackupCommands sends pre-backup commands to the server if it is running and waits for them to
+// take effect. The returned function sends the post-backup commands and must always be called.
+func (process *Process) sendBackupCommands(config BackupJobConfig) func() {
+	if process.Online.Load() != 1 {
+		return func() {}
+	}
+	for _, command := range config.PreCommands {
+		process.SendControlCommand(command)
+	}
+	if len(config.PreCommands) > 0 {
+		<-time.After(config.commandDelay())
+	}
+	return func() {
+		if process.Online.Load() == 1 {
+			for _, command := range config.PostCommands {
+				process.SendControlCommand(command)
+			}
+		}
+	}
+}
+
+// CreateBackup creates a backup of the server from the backup definition with the given name,
+// then deletes old backups according to the retention rules of the definition.
+func (process *Process) CreateBackup(name string) (BackupInfo, error) {
+	config, dir, err := process.getBackupConfig(name)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	ext, err := config.extension()
+	if err != nil {
+		return BackupInfo{}, err
+	} else if _, loaded := backupsInProgress.LoadOrStore(process.Name, true); loaded {
+		return BackupInfo{}, errBackupInProgress
+	}
+	defer backupsInProgress.Delete(process.Name)
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	process.SendConsoleOutput("[Octyne] Creating backup " + name + " of server " + process.Name)
+	created := time.Now().UTC()
+	file := created.Format(backupTimeLayout) + ext
+	partialFile := filepath.Join(dir, "."+file+".partial")
+	err = (func() error {
+		defer process.sendBackupCommands(config.BackupJobConfig)()
+		archiveFile, err := os.Create(partialFile)
+		if err != nil {
+			return err
+		}
+		defer archiveFile.Close()
+		process.ServerConfigMutex.RLock()
+		serverDir := process.Directory
+		process.ServerConfigMutex.RUnlock()
+		err = writeArchive(archiveFile, ext, serverDir, config.backupPaths(), config.excludeFilter())
+		if err != nil {
+			return err
+		}
+		return archiveFile.Close()
+	})()
+	if err == nil {
+		err = os.Rename(partialFile, filepath.Join(dir, file))
+	}
+	if err != nil {
+		os.Remove(partialFile)
+		process.SendConsoleOutput("[Octyne] Failed to create backup " + name + " of server " + process.Name)
+		return BackupInfo{}, err
+	}
+	process.SendConsoleOutput("[Octyne] Created backup " + name + " of server " + process.Name)
+
+	// Delete old backups.
+	backups, err := listBackups(dir)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	times := make([]time.Time, len(backups))
+	for index, backup := range backups {
+		times[index] = time.Unix(backup.Time, 0)
+	}
+	for _, index := range config.Retention.expired(times) {
+		err = os.Remove(filepath.Join(dir, backups[index].File))
+		if err != nil {
+			return BackupInfo{}, err
+		}
+	}
+	stat, err := os.Stat(filepath.Join(dir, file))
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	return BackupInfo{File: file, Size: stat.Size(), Time: created.Unix()}, nil
+}
+
+// RestoreBackup replaces the backed up files in the server directory with the contents of a backup.
+// The server must not be running.
+func (process *Process) RestoreBackup(name string, file string) error {
+	config, dir, err := process.getBackupConfig(name)
+	if err != nil {
+		return err
+	}
+	archivePath := filepath.Join(dir, file)
+	if stat, err := os.Stat(archivePath); os.IsNotExist(err) || (err == nil && !stat.Mode().IsRegular()) {
+		return errBackupNotFound
+	} else if err != nil {
+		return err
+	} else if process.Online.Load() == 1 {
+		return errServerRunning
+	} else if _, loaded := backupsInProgress.LoadOrStore(process.Name, true); loaded {
+		return errBackupInProgress
+	}
+	defer backupsInProgress.Delete(process.Name)
+	process.ServerConfigMutex.RLock()
+	serverDir := process.Directory
+	process.ServerConfigMutex.RUnlock()
+	err = replaceServerFiles(serverDir, config.backupPaths(), config.excludeFilter(), func() error {
+		if strings.HasSuffix(archivePath, ".zip") {
+			return system.UnzipFile(archivePath, serverDir)
+		}
+		return system.ExtractTarFile(archivePath, serverDir)
+	})
+	if err == nil {
+		process.SendConsoleOutput("[Octyne] Restored backup " + name + " (" + file + ") of server " + process.Name)
+	}
+	return err
+}
+
+// writeArchive writes an archive containing files and folders in dir to w. The archive type and
+// compression are determined by ext, which must be an extension listed in backupFormats.
+func writeArchive(w io.Writer, ext string, dir string, files []string, filter func(string) bool) error {
+	if ext == ".zip" {
+		archive := zip.NewWriter(w)
+		for _, file := range files {
+			err := system.AddFilteredFileToZip(archive, dir, file, true, filter)
+			if err != nil {
+				archive.Close()
+				return err
+			}
+		}
+		return archive.Close()
+	}
+	var compressionWriter io.WriteCloser
+	archive := tar.NewWriter(w)
+	switch ext {
+	case ".tar.gz":
+		compressionWriter = gzip.NewWriter(w)
+	case ".tar.xz":
+		compressionWriter = system.NativeCompressionWriter(w, "xz")
+	case ".tar.zst":
+		compressionWriter = system.NativeCompressionWriter(w, "zstd")
+	}
+	if compressionWriter != nil {
+		archive = tar.NewWriter(compressionWriter)
+	}
+	var err error
+	for _, file := range files {
+		if err = system.AddFilteredFileToTar(archive, dir, file, filter); err != nil {
+			break
+		}
+	}
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if compressionWriter != nil {
+		if closeErr := compressionWriter.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// replaceServerFiles moves the given slash-separated paths in the server directory aside, then
+// calls restore to recreate them. If restore fails, the original files are moved back. Files which
+// were rejected by filter are kept after a successful restore, since they weren't backed up.
+func replaceServerFiles(dir string, paths []string, filter func(string) bool, restore func() error) error {
+	tmp, err := os.MkdirTemp(dir, ".octyne-restore-")
+	if err != nil {
+		return err
+	}
+	moved := make([]string, 0)
+	revert := func(err error) error {
+		for index := len(moved) - 1; index >= 0; index-- {
+			original := filepath.Join(dir, filepath.FromSlash(moved[index]))
+			if err1 := os.RemoveAll(original); err1 != nil {
+				return fmt.Errorf("error reverting restore: %w\noriginal err: %w", err1, err)
+			}
+			err1 := os.Rename(filepath.Join(tmp, filepath.FromSlash(moved[index])), original)
+			if err1 != nil {
+				return fmt.Errorf("error reverting restore: %w\noriginal err: %w", err1, err)
+			}
+		}
+		if err1 := os.RemoveAll(tmp); err1 != nil {
+			return fmt.Errorf("error reverting restore: %w\noriginal err: %w", err1, err)
+		}
+		return err
+	}
+
+	// Move the existing files into the temporary folder.
+	for _, file := range paths {
+		toMove := []string{file}
+		if file == "." {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return revert(err)
+			}
+			toMove = make([]string, 0, len(entries))
+			for _, entry := range entries {
+				if entry.Name() != filepath.Base(tmp) {
+					toMove = append(toMove, entry.Name())
+				}
+			}
+		}
+		for _, file := range toMove {
+			src := filepath.Join(dir, filepath.FromSlash(file))
+			dest := filepath.Join(tmp, filepath.FromSlash(file))
+			if _, err := os.Lstat(src); os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return revert(err)
+			} else if err = os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+				return revert(err)
+			} else if err = os.Rename(src, dest); err != nil {
+				return revert(err)
+			}
+			moved = append(moved, file)
+		}
+	}
+
+	if err := restore(); err != nil {
+		return revert(err)
+	}
+
+	// Move back files which were not backed up, then delete the temporary folder.
+	err = filepath.WalkDir(tmp, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || file == tmp {
+			return err
+		}
+		rel, err := filepath.Rel(tmp, file)
+		if err != nil || filter(filepath.ToSlash(rel)) {
+			return err
+		}
+		original := filepath.Join(dir, rel)
+		if _, err := os.Lstat(original); err == nil {
+			return nil // Don't overwrite restored files.
+		} else if err = os.MkdirAll(filepath.Dir(original), os.ModePerm); err != nil {
+			return err
+		} else if err = os.Rename(file, original); err != nil {
+			return err
+		} else if entry.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(tmp)
+}
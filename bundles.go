@@ -0,0 +1,125 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/retrixe/octyne/system"
+)
+
+var errInvalidServerBundle = errors.New("invalid server bundle")
+
+// serverBundleManifestFile is the name of the manifest, which is the first file in server bundles.
+const serverBundleManifestFile = "octyne-server.json"
+
+// serverBundleManifest describes the server exported in a server bundle. The server directory is
+// stored in the bundle in a folder named Files.
+type serverBundleManifest struct {
+	Version   int                        `json:"version"`
+	Name      string                     `json:"name"`
+	Directory string                     `json:"directory"`
+	Files     string                     `json:"files"`
+	Config    map[string]json.RawMessage `json:"config"`
+}
+
+// writeServerBundle writes a tar.gz bundle containing the server directory and its config to w.
+func writeServerBundle(w io.Writer, name string, directory string, config map[string]json.RawMessage) error {
+	directory, err := filepath.Abs(directory)
+	if err != nil {
+		return err
+	}
+	manifest, err := json.Marshal(serverBundleManifest{
+		Version:   1,
+		Name:      name,
+		Directory: directory,
+		Files:     filepath.Base(directory),
+		Config:    config,
+	})
+	if err != nil {
+		return err
+	}
+	compressionWriter := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressionWriter)
+	err = archive.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     serverBundleManifestFile,
+		Size:     int64(len(manifest)),
+		Mode:     0644,
+		ModTime:  time.Now(),
+	})
+	if err == nil {
+		_, err = archive.Write(manifest)
+	}
+	if err == nil {
+		// Octyne's temporary files are excluded, just like with backups.
+		filter := (&BackupJobConfig{}).excludeFilter()
+		err = system.AddFilteredFileToTar(archive, filepath.Dir(directory), filepath.Base(directory), filter)
+	}
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := compressionWriter.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readServerBundleManifest reads the manifest at the start of a server bundle. The remaining files
+// in the bundle can then be read from the returned tar.Reader.
+func readServerBundleManifest(r io.Reader) (serverBundleManifest, *tar.Reader, error) {
+	var manifest serverBundleManifest
+	compressionReader, err := gzip.NewReader(r)
+	if err != nil {
+		return manifest, nil, errInvalidServerBundle
+	}
+	archive := tar.NewReader(compressionReader)
+	header, err := archive.Next()
+	if err != nil || header.Name != serverBundleManifestFile {
+		return manifest, nil, errInvalidServerBundle
+	}
+	contents, err := io.ReadAll(io.LimitReader(archive, 1<<20))
+	if err != nil {
+		return manifest, nil, err
+	} else if json.Unmarshal(contents, &manifest) != nil ||
+		manifest.Version != 1 || !isValidServerName(manifest.Files) {
+		return manifest, nil, errInvalidServerBundle
+	}
+	return manifest, archive, nil
+}
+
+// remapPath replaces the longest prefix of a path found in remap with its replacement.
+func remapPath(file string, remap map[string]string) string {
+	longest, replacement := "", ""
+	for from, to := range remap {
+		from = filepath.Clean(from)
+		if filepathHasPrefix(file, from) && len(from) > len(longest) {
+			longest, replacement = from, to
+		}
+	}
+	if longest == "" {
+		return file
+	}
+	return filepath.Join(replacement, file[len(longest):])
+}
+
+// remapConfigPaths remaps all strings in a decoded JSON value which are paths found in remap.
+func remapConfigPaths(value interface{}, remap map[string]string) interface{} {
+	switch value := value.(type) {
+	case string:
+		return remapPath(value, remap)
+	case []interface{}:
+		for index, item := range value {
+			value[index] = remapConfigPaths(item, remap)
+		}
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = remapConfigPaths(item, remap)
+		}
+	}
+	return value
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/tailscale/hujson"
 )
@@ -15,17 +17,27 @@ var defaultConfig = Config{
 	Logging: LoggingConfig{
 		Enabled: true,
 		Path:    "logs",
+		Console: ConsoleLoggingConfig{MaxSize: 10, MaxFiles: 10, Compress: true},
+		History: HistoryConfig{Enabled: true, MaxEntries: 10000},
 	},
 	WebUI: WebUIConfig{
 		Enabled: true,
 		Port:    7877,
 	},
-	Redis:   RedisConfig{URL: "redis://localhost"},
-	Servers: map[string]ServerConfig{},
+	Redis:     RedisConfig{URL: "redis://localhost"},
+	Templates: TemplatesConfig{Directory: "templates"},
+	Servers:   map[string]ServerConfig{},
 }
 
-func ReadConfig() (Config, error) {
+// newDefaultConfig returns a copy of the default config, which doesn't share the servers map.
+func newDefaultConfig() Config {
 	config := defaultConfig
+	config.Servers = map[string]ServerConfig{}
+	return config
+}
+
+func ReadConfig() (Config, error) {
+	config := newDefaultConfig()
 	contents, err := os.ReadFile(ConfigJsonPath)
 	if err != nil {
 		return config, err
@@ -41,6 +53,53 @@ func ReadConfig() (Config, error) {
 	return config, nil
 }
 
+// ConfigJsonMutex is locked while config.json is being modified by Octyne.
+var ConfigJsonMutex sync.Mutex
+
+// PatchConfig modifies config.json with a JSON Patch (RFC 6902) and returns the new config.
+// Unlike rewriting config.json, comments and formatting in the file are preserved. The patch is
+// created by a function which is passed the current contents of config.json.
+func PatchConfig(createPatch func(contents *hujson.Value) ([]byte, error)) (Config, error) {
+	ConfigJsonMutex.Lock()
+	defer ConfigJsonMutex.Unlock()
+	config := newDefaultConfig()
+	contents, err := os.ReadFile(ConfigJsonPath)
+	if err != nil {
+		return config, err
+	}
+	value, err := hujson.Parse(contents)
+	if err != nil {
+		return config, err
+	}
+	patch, err := createPatch(&value)
+	if err != nil {
+		return config, err
+	} else if err = value.Patch(patch); err != nil {
+		return config, err
+	}
+	contents = value.Pack()
+	standardized, err := hujson.Standardize(append([]byte{}, contents...))
+	if err != nil {
+		return config, err
+	} else if err = json.Unmarshal(standardized, &config); err != nil {
+		return config, err
+	}
+	err = os.WriteFile(ConfigJsonPath+"~", contents, 0666)
+	if err != nil {
+		return config, err
+	}
+	return config, os.Rename(ConfigJsonPath+"~", ConfigJsonPath)
+}
+
+// jsonPointer creates a JSON Pointer (RFC 6901) from a list of object keys.
+func jsonPointer(keys ...string) string {
+	pointer := ""
+	for _, key := range keys {
+		pointer += "/" + strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+	}
+	return pointer
+}
+
 // Config is the main config for Octyne.
 type Config struct {
 	Port       uint16                  `json:"port"`
@@ -49,7 +108,24 @@ type Config struct {
 	Redis      RedisConfig             `json:"redis"`
 	Logging    LoggingConfig           `json:"logging"`
 	WebUI      WebUIConfig             `json:"webUI"`
+	Templates  TemplatesConfig         `json:"templates"`
 	Servers    map[string]ServerConfig `json:"servers"`
+	// CommandPolicies restrict the commands which users can send to the console of servers.
+	CommandPolicies map[string]CommandPolicyConfig `json:"commandPolicies,omitempty"`
+}
+
+// CommandPolicyConfig is the config for a command policy, which restricts the commands a group of
+// users can send to the console of servers.
+type CommandPolicyConfig struct {
+	Users   []string `json:"users"`
+	Servers []string `json:"servers,omitempty"` // Empty applies the policy to all servers.
+	Allow   []string `json:"allow,omitempty"`   // Empty allows all commands which aren't denied.
+	Deny    []string `json:"deny,omitempty"`
+}
+
+// TemplatesConfig contains the path to the folder containing server templates.
+type TemplatesConfig struct {
+	Directory string `json:"directory"`
 }
 
 // WebUIConfig contains whether or not the Web UI is enabled.
@@ -81,9 +157,70 @@ type UnixSocketConfig struct {
 
 // ServerConfig is the config for individual servers.
 type ServerConfig struct {
-	Enabled   bool   `json:"enabled"`
-	Directory string `json:"directory"`
-	Command   string `json:"command"`
+	Enabled      bool                     `json:"enabled"`
+	Directory    string                   `json:"directory"`
+	Command      string                   `json:"command"`
+	StopCommands []string                 `json:"stopCommands,omitempty"`
+	StopTimeout  string                   `json:"stopTimeout,omitempty"`
+	Backups      map[string]BackupConfig  `json:"backups,omitempty"`
+	Snapshots    *SnapshotConfig          `json:"snapshots,omitempty"`
+	Console      ConsoleConfig            `json:"console"`
+	Triggers     map[string]TriggerConfig `json:"triggers,omitempty"`
+	RCON         *RCONConfig              `json:"rcon,omitempty"`
+	Minecraft    *MinecraftConfig         `json:"minecraft,omitempty"`
+}
+
+// MinecraftConfig is the config for getting the status of a Minecraft server.
+type MinecraftConfig struct {
+	Host string `json:"host,omitempty"` // Default is 127.0.0.1.
+	Port uint16 `json:"port,omitempty"` // Default is 25565.
+	// Query gets the full player list using the Query protocol, which must be enabled on the server.
+	Query     bool   `json:"query,omitempty"`
+	QueryPort uint16 `json:"queryPort,omitempty"` // Default is the same as Port.
+}
+
+// RCONConfig is the config for connecting to a server over RCON.
+type RCONConfig struct {
+	Host     string `json:"host,omitempty"` // Default is 127.0.0.1.
+	Port     uint16 `json:"port"`
+	Password string `json:"password"`
+	// Console routes input from the console through RCON instead of the server's stdin.
+	Console bool `json:"console,omitempty"`
+}
+
+// ConsoleConfig contains settings for the console of a server.
+type ConsoleConfig struct {
+	ScrollbackLines int `json:"scrollbackLines,omitempty"`
+	ScrollbackBytes int `json:"scrollbackBytes,omitempty"`
+	// ClientQueueSize is the number of messages which can be queued for each console client.
+	ClientQueueSize int `json:"clientQueueSize,omitempty"`
+	// SlowClients is either drop or disconnect, and decides what happens to output for clients
+	// whose queue is full.
+	SlowClients string `json:"slowClients,omitempty"`
+	// Parsing parses console output into plain text, HTML and fields like the log level.
+	Parsing ConsoleParsingConfig `json:"parsing"`
+	// Input limits the input which can be sent to the console of a server.
+	Input ConsoleInputConfig `json:"input"`
+}
+
+// ConsoleInputConfig contains limits on input sent to the console of a server. Zero values use the
+// defaults.
+type ConsoleInputConfig struct {
+	MaxLineLength   int     `json:"maxLineLength,omitempty"`   // Bytes, default is 4096.
+	RateLimit       float64 `json:"rateLimit,omitempty"`       // Lines per second for each connection, default is 10.
+	ServerRateLimit float64 `json:"serverRateLimit,omitempty"` // Lines per second for the server, default is 50.
+	MaxPasteLines   int     `json:"maxPasteLines,omitempty"`   // Lines in a multi-line paste, default is 1000.
+	// WriteTimeout is how long input can wait to be written to the server's stdin, default is 5s.
+	WriteTimeout string `json:"writeTimeout,omitempty"`
+}
+
+// ConsoleParsingConfig contains whether console output of a server is parsed, and the patterns
+// used to parse lines into fields.
+type ConsoleParsingConfig struct {
+	Enabled bool `json:"enabled"`
+	// Patterns are regular expressions with named groups for each field, the first matching pattern
+	// is used. Default patterns match the log4j formats used by Minecraft servers and proxies.
+	Patterns []string `json:"patterns,omitempty"`
 }
 
 // UnmarshalJSON unmarshals ServerConfig and sets default values.
@@ -95,11 +232,72 @@ func (c *ServerConfig) UnmarshalJSON(data []byte) error {
 	return err
 }
 
+// BackupJobConfig contains the files to back up, when to back them up, and how many backups
+// to retain, along with console commands to run before and after the backup.
+type BackupJobConfig struct {
+	Paths        []string              `json:"paths,omitempty"`
+	Exclude      []string              `json:"exclude,omitempty"`
+	Interval     string                `json:"interval,omitempty"`
+	Retention    BackupRetentionConfig `json:"retention"`
+	PreCommands  []string              `json:"preCommands,omitempty"`
+	PostCommands []string              `json:"postCommands,omitempty"`
+	CommandDelay string                `json:"commandDelay,omitempty"`
+}
+
+// BackupRetentionConfig contains the number of backups to keep. Zero values keep everything.
+type BackupRetentionConfig struct {
+	Last   int `json:"last,omitempty"`
+	Daily  int `json:"daily,omitempty"`
+	Weekly int `json:"weekly,omitempty"`
+}
+
+// TriggerConfig is the config for a console trigger of a server, which performs actions when a
+// line of console output matches its pattern.
+type TriggerConfig struct {
+	Pattern  string `json:"pattern"`
+	Cooldown string `json:"cooldown,omitempty"` // Minimum time between actions, default is 1m.
+	Log      bool   `json:"log,omitempty"`
+	Webhook  string `json:"webhook,omitempty"`
+	Command  string `json:"command,omitempty"`
+	Restart  bool   `json:"restart,omitempty"`
+}
+
+// BackupConfig is the config for a backup definition of a server.
+type BackupConfig struct {
+	BackupJobConfig
+	Destination string `json:"destination"`
+	Format      string `json:"format,omitempty"`
+}
+
+// SnapshotConfig is the config for deduplicated snapshots of a server.
+type SnapshotConfig struct {
+	BackupJobConfig
+	Store string `json:"store"`
+}
+
 // LoggingConfig is the config for action logging.
 type LoggingConfig struct {
-	Enabled bool            `json:"enabled"`
-	Path    string          `json:"path"`
-	Actions map[string]bool `json:"actions"`
+	Enabled bool                 `json:"enabled"`
+	Path    string               `json:"path"`
+	Actions map[string]bool      `json:"actions"`
+	Console ConsoleLoggingConfig `json:"console"`
+	History HistoryConfig        `json:"history"`
+}
+
+// HistoryConfig is the config for recording the commands sent to the console of servers.
+type HistoryConfig struct {
+	Enabled    bool `json:"enabled"`
+	MaxEntries int  `json:"maxEntries"`
+}
+
+// ConsoleLoggingConfig is the config for logging the console output of servers to files.
+type ConsoleLoggingConfig struct {
+	Enabled  bool   `json:"enabled"`
+	MaxSize  int    `json:"maxSize"`          // Megabytes, before the log file is rotated.
+	MaxFiles int    `json:"maxFiles"`         // Rotated log files to keep, 0 keeps all of them.
+	MaxAge   int    `json:"maxAge,omitempty"` // Days to keep rotated log files, 0 keeps them forever.
+	Rotate   string `json:"rotate,omitempty"` // Interval at which the log file is rotated, e.g. 24h.
+	Compress bool   `json:"compress"`         // Whether rotated log files should be gzipped.
 }
 
 // ShouldLog returns whether or not a particular action should be logged.
@@ -11,6 +11,8 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/puzpuzpuz/xsync/v3"
@@ -54,17 +56,33 @@ func CreateZapLogger(config LoggingConfig) *zap.SugaredLogger {
 // ExposedProcess contains Process along with connected clients and cached output.
 type ExposedProcess struct {
 	*Process
-	Clients     *xsync.MapOf[chan interface{}, string]
-	Console     string
+	Clients    *xsync.MapOf[*ConsoleClient, string]
+	Scrollback *ConsoleBuffer
+	ConsoleLog *ConsoleLog
+	History    *CommandHistory
+	// RCONConnection is the connection to the server over RCON, if it has been used.
+	RCONConnection rconConnection
+	// InputLimiter limits the rate of input sent to the server.
+	InputLimiter rateLimiter
+	// MinecraftStatusCache caches the status of the server, if it is a Minecraft server.
+	MinecraftStatusCache minecraftStatusCache
+	// ConsoleLock is held while output is added to the scrollback and sent to clients.
 	ConsoleLock sync.RWMutex
+	// DroppedLines is the number of lines of output dropped for slow clients.
+	DroppedLines atomic.Int64
+	// TriggersFired stores when each console trigger of the server last performed its actions.
+	TriggersFired *xsync.MapOf[string, time.Time]
 }
 
 // Ticket is a one-time ticket usable by browsers to quickly authenticate with the WebSocket API.
+// When used for a download, the ticket is bound to it instead, and can be reused for GET and HEAD
+// requests to the same download until it expires, so that downloads can be resumed.
 type Ticket struct {
-	Time   int64
-	User   string
-	Token  string
-	IPAddr string
+	Time     int64
+	User     string
+	Token    string
+	IPAddr   string
+	Download string // The download the ticket is bound to, if it has been used for one.
 }
 
 // Connector is used to create an HTTP API for external apps to talk with octyne.
@@ -74,6 +92,8 @@ type Connector struct {
 	*Logger
 	Processes *xsync.MapOf[string, *ExposedProcess]
 	Tickets   *xsync.MapOf[string, Ticket]
+	// Config is the current config, for endpoints which need top-level settings.
+	Config atomic.Pointer[Config]
 }
 
 // GetIP gets an IP address from http.Request.RemoteAddr.
@@ -88,6 +108,78 @@ func GetIP(r *http.Request) string {
 	return r.RemoteAddr[:index]
 }
 
+// loadDownloadTicket returns the ticket in the query of a GET or HEAD request for a download, if it
+// is valid. The ticket is bound to the download the first time it's used, and is then only valid
+// for requests to the same path with the same query parameters until it expires.
+func (connector *Connector) loadDownloadTicket(r *http.Request) (Ticket, bool) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return Ticket{}, false
+	}
+	query := r.URL.Query()
+	id := query.Get("ticket")
+	query.Del("ticket")
+	download := r.URL.Path + "?" + query.Encode()
+	ip := GetIP(r)
+	ticket, ok := connector.Tickets.Compute(id, func(ticket Ticket, loaded bool) (Ticket, bool) {
+		if loaded && ticket.Download == "" && ticket.IPAddr == ip {
+			ticket.Download = download
+		}
+		return ticket, !loaded // Don't store tickets which don't exist.
+	})
+	return ticket, ok && ticket.IPAddr == ip && ticket.Download == download
+}
+
+// loadConsoleTicket returns the one-time ticket in the query of a request for the console, if it is
+// valid, and deletes it. Tickets bound to a download are rejected, and kept for the download.
+func (connector *Connector) loadConsoleTicket(r *http.Request) (Ticket, bool) {
+	var ticket Ticket
+	var unused bool
+	connector.Tickets.Compute(r.URL.Query().Get("ticket"), func(value Ticket, loaded bool) (Ticket, bool) {
+		ticket, unused = value, loaded && value.Download == ""
+		return value, !loaded || unused
+	})
+	return ticket, unused && ticket.IPAddr == GetIP(r)
+}
+
+// validateTicketWithPermAndReject authenticates a download request with the ticket in its query if
+// it has a valid one, else with its Authorization header, and checks if the user has a permission.
+// If they don't, the request is rejected.
+func (connector *Connector) validateTicketWithPermAndReject(
+	w http.ResponseWriter, r *http.Request, permission string,
+) (string, bool) {
+	ticket, ticketExists := connector.loadDownloadTicket(r)
+	return connector.validateWithTicketAndReject(w, r, ticket, ticketExists, permission)
+}
+
+// validateConsoleTicketWithPermAndReject is like validateTicketWithPermAndReject, but for requests
+// for the console, which use one-time tickets.
+func (connector *Connector) validateConsoleTicketWithPermAndReject(
+	w http.ResponseWriter, r *http.Request, permission string,
+) (string, bool) {
+	ticket, ticketExists := connector.loadConsoleTicket(r)
+	return connector.validateWithTicketAndReject(w, r, ticket, ticketExists, permission)
+}
+
+// validateWithTicketAndReject authenticates a request with a ticket if it is valid, else with its
+// Authorization header, and rejects the request if the user doesn't have a permission.
+func (connector *Connector) validateWithTicketAndReject(
+	w http.ResponseWriter, r *http.Request, ticket Ticket, ticketExists bool, permission string,
+) (string, bool) {
+	if !ticketExists {
+		return connector.ValidateWithPermAndReject(w, r, permission)
+	}
+	hasPerm, err := connector.Authenticator.HasPerm(ticket.User, permission)
+	if err != nil {
+		log.Println("An error occurred while validating authorization for an HTTP request!", err)
+		httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+		return "", false
+	} else if !hasPerm {
+		httpError(w, "You are not allowed to access this resource!", http.StatusForbidden)
+		return "", false
+	}
+	return ticket.User, true
+}
+
 // WrapEndpointWithCtx provides Connector instances to HTTP endpoint handler functions.
 func WrapEndpointWithCtx(
 	connector *Connector,
@@ -118,12 +210,13 @@ func InitializeConnector(config *Config) *Connector {
 		Tickets:       xsync.NewMapOf[string, Ticket](),
 		Authenticator: &auth.ReplaceableAuthenticator{Engine: authenticator},
 		Upgrader: &websocket.Upgrader{
-			Subprotocols: []string{"console-v2"},
+			Subprotocols: []string{"console-v3", "console-v2"},
 			CheckOrigin: func(_ *http.Request) bool {
 				return true
 			},
 		},
 	}
+	connector.Config.Store(config)
 	return connector
 }
 
@@ -146,11 +239,25 @@ func (connector *Connector) GetMux(webUi bool) *http.ServeMux {
 		DELETE /accounts?username=username
 
 		GET /servers?extrainfo=true/false
+		WS /servers/console?servers=servers&since=since&ticket=ticket
+		POST /servers/import?name=name&directory=directory&onConflict=fail/rename&remap=remap
 
 		GET /server/{id} (statistics like uptime, CPU and RAM)
 		POST /server/{id} (to start and stop a server)
+		PUT /server/{id}?createDirectory=true/false&cloneFrom=server (to create or edit a server)
+		DELETE /server/{id}?directory=keep/delete/archive
+		GET /server/{id}/export?ticket=ticket
 
-		WS /server/{id}/console?ticket=ticket (has console-v2 protocol)
+		GET /templates
+		POST /templates/{name}
+
+		WS /server/{id}/console?ticket=ticket&since=seq (has console-v2 and console-v3 protocols)
+		POST /server/{id}/console
+		GET /server/{id}/console/stream?ticket=ticket&since=seq
+		GET /server/{id}/console/history?user=user&mine=true/false&from=time&to=time&limit=100
+		POST /server/{id}/rcon
+		GET /server/{id}/console/logs?file=file&ticket=ticket (file is optional)
+		GET /server/{id}/console/search?q=query&regex=false&level=levels&from=time&to=time&limit=100&context=0
 
 		GET /server/{id}/files?path=path
 		GET /server/{id}/file?path=path&ticket=ticket
@@ -158,9 +265,33 @@ func (connector *Connector) GetMux(webUi bool) *http.ServeMux {
 		POST /server/{id}/folder?path=path
 		DELETE /server/{id}/file?path=path
 		PATCH /server/{id}/file (moving files, copying files and renaming them)
+		POST /server/{id}/uploads?path=path
+		GET /server/{id}/uploads/{upload}
+		PATCH /server/{id}/uploads/{upload}?offset=offset
+		POST /server/{id}/uploads/{upload}
+		DELETE /server/{id}/uploads/{upload}
+		GET /server/{id}/file/content?path=path
+		PUT /server/{id}/file/content?path=path (requires If-Match to overwrite existing files)
+		GET /server/{id}/properties?path=path (path is optional, default: server.properties)
+		PATCH /server/{id}/properties?path=path&restart=false
+		GET /server/{id}/plugins
+		PATCH /server/{id}/plugins
 
 		POST /server/{id}/compress?path=path&compress=true/false (compress is optional, default: true)
 		POST /server/{id}/decompress?path=path
+
+		GET /server/{id}/backups?name=name (name is optional)
+		POST /server/{id}/backups?name=name
+		DELETE /server/{id}/backups?name=name&file=file
+		GET /server/{id}/backups/download?name=name&file=file&ticket=ticket
+		POST /server/{id}/backups/restore?name=name&file=file
+
+		GET /server/{id}/snapshots
+		POST /server/{id}/snapshots
+		DELETE /server/{id}/snapshots?snapshot=snapshot
+		POST /server/{id}/snapshots/restore?snapshot=snapshot
+		POST /server/{id}/snapshots/prune
+		POST /server/{id}/snapshots/verify?snapshot=snapshot (snapshot is optional)
 	*/
 
 	prefix := ""
@@ -181,28 +312,57 @@ func (connector *Connector) GetMux(webUi bool) *http.ServeMux {
 	mux.Handle(prefix+"/config", WrapEndpointWithCtx(connector, configEndpoint))
 	mux.Handle(prefix+"/config/reload", WrapEndpointWithCtx(connector, configReloadEndpoint))
 	mux.Handle(prefix+"/servers", WrapEndpointWithCtx(connector, serversEndpoint))
+	mux.Handle(prefix+"/servers/import", WrapEndpointWithCtx(connector, serversImportEndpoint))
+	mux.Handle(prefix+"/servers/console", WrapEndpointWithCtx(connector, multiConsoleEndpoint))
 	mux.Handle(prefix+"/server/{id}", WrapEndpointWithCtx(connector, serverEndpoint))
+	mux.Handle(prefix+"/server/{id}/export", WrapEndpointWithCtx(connector, serverExportEndpoint))
+	mux.Handle(prefix+"/templates", WrapEndpointWithCtx(connector, templatesEndpoint))
+	mux.Handle(prefix+"/templates/{name}", WrapEndpointWithCtx(connector, templateEndpoint))
 	mux.Handle(prefix+"/server/{id}/console", WrapEndpointWithCtx(connector, consoleEndpoint))
+	mux.Handle(prefix+"/server/{id}/console/stream", WrapEndpointWithCtx(connector, consoleStreamEndpoint))
+	mux.Handle(prefix+"/server/{id}/console/history", WrapEndpointWithCtx(connector, consoleHistoryEndpoint))
+	mux.Handle(prefix+"/server/{id}/rcon", WrapEndpointWithCtx(connector, rconEndpoint))
+	mux.Handle(prefix+"/server/{id}/console/logs", WrapEndpointWithCtx(connector, consoleLogsEndpoint))
+	mux.Handle(prefix+"/server/{id}/console/search", WrapEndpointWithCtx(connector, consoleSearchEndpoint))
 
 	mux.Handle(prefix+"/server/{id}/files", WrapEndpointWithCtx(connector, filesEndpoint))
 	mux.Handle(prefix+"/server/{id}/file", WrapEndpointWithCtx(connector, fileEndpoint))
+	mux.Handle(prefix+"/server/{id}/uploads", WrapEndpointWithCtx(connector, uploadsEndpoint))
+	mux.Handle(prefix+"/server/{id}/uploads/{upload}", WrapEndpointWithCtx(connector, uploadEndpoint))
+	mux.Handle(prefix+"/server/{id}/file/content", WrapEndpointWithCtx(connector, fileContentEndpoint))
 	mux.Handle(prefix+"/server/{id}/folder", WrapEndpointWithCtx(connector, folderEndpoint))
+	mux.Handle(prefix+"/server/{id}/properties", WrapEndpointWithCtx(connector, propertiesEndpoint))
+	mux.Handle(prefix+"/server/{id}/plugins", WrapEndpointWithCtx(connector, pluginsEndpoint))
 	mux.Handle(prefix+"/server/{id}/compress", WrapEndpointWithCtx(connector, compressionEndpoint))
 	mux.Handle(prefix+"/server/{id}/compress/v2", WrapEndpointWithCtx(connector, compressionEndpoint))
 	mux.Handle(prefix+"/server/{id}/decompress", WrapEndpointWithCtx(connector, decompressionEndpoint))
+
+	mux.Handle(prefix+"/server/{id}/backups", WrapEndpointWithCtx(connector, backupsEndpoint))
+	mux.Handle(prefix+"/server/{id}/backups/download", WrapEndpointWithCtx(connector, backupDownloadEndpoint))
+	mux.Handle(prefix+"/server/{id}/backups/restore", WrapEndpointWithCtx(connector, backupRestoreEndpoint))
+	mux.Handle(prefix+"/server/{id}/snapshots", WrapEndpointWithCtx(connector, snapshotsEndpoint))
+	mux.Handle(prefix+"/server/{id}/snapshots/{operation}", WrapEndpointWithCtx(connector, snapshotOperationEndpoint))
 	return mux
 }
 
 // AddProcess adds a process to the connector to be accessed via the HTTP API.
 func (connector *Connector) AddProcess(proc *Process) {
+	proc.ServerConfigMutex.RLock()
+	scrollback := NewConsoleBuffer(proc.Console.ScrollbackLines, proc.Console.ScrollbackBytes)
+	proc.ServerConfigMutex.RUnlock()
 	process := &ExposedProcess{
-		Process: proc,
-		Clients: xsync.NewMapOf[chan interface{}, string](),
-		Console: "",
+		Process:       proc,
+		Clients:       xsync.NewMapOf[*ConsoleClient, string](),
+		Scrollback:    scrollback,
+		ConsoleLog:    NewConsoleLog(connector.Config.Load().Logging, proc.Name),
+		History:       NewCommandHistory(connector.Config.Load().Logging, proc.Name),
+		TriggersFired: xsync.NewMapOf[string, time.Time](),
 	}
+	proc.StateChanged = process.broadcastEvent
 	connector.Processes.Store(process.Name, process)
 	// Run a function which will monitor the console output of this process.
 	go (func() {
+		logFailed := false
 		for {
 			scanner := bufio.NewScanner(process.Output)
 			scanner.Split(bufio.ScanLines)
@@ -210,27 +370,76 @@ func (connector *Connector) AddProcess(proc *Process) {
 			scanner.Buffer(buf, 1024*1024)
 			for scanner.Scan() {
 				m := scanner.Text()
-				// Truncate the console scrollback to 2500 to prevent excess memory usage and download cost.
-				// TODO: These limits aren't exactly the best, it maxes up to 2.5 GB.
-				(func() {
+				process.ServerConfigMutex.RLock()
+				disconnect := process.Console.SlowClients == "disconnect"
+				parsing := process.Console.Parsing
+				process.ServerConfigMutex.RUnlock()
+				line := ConsoleLine{Text: m}
+				if parsing.Enabled {
+					parseConsoleLine(&line, parsing.Patterns)
+				}
+				line = (func() ConsoleLine {
 					process.ConsoleLock.Lock()
 					defer process.ConsoleLock.Unlock()
-					truncate := strings.Split(process.Console, "\n")
-					if len(truncate) >= 2500 {
-						process.Console = strings.Join(truncate[len(truncate)-2500:], "\n")
-					}
-					process.Console = process.Console + "\n" + m
-					process.Clients.Range(func(connection chan interface{}, _ string) bool {
-						connection <- m
+					line := process.Scrollback.Append(line)
+					process.Clients.Range(func(client *ConsoleClient, _ string) bool {
+						if !client.Send(line) {
+							process.DroppedLines.Add(1)
+							if disconnect {
+								process.Clients.Delete(client)
+								client.Close()
+							}
+						}
 						return true
 					})
+					return line
 				})()
+				// Only log the first dropped line, until queueing lines succeeds again.
+				if err := process.ConsoleLog.Queue(line); err != nil && !logFailed {
+					log.Println("Failed to write to console log of server "+process.Name+"!", err)
+					logFailed = true
+				} else if err == nil {
+					logFailed = false
+				}
+				process.runTriggers(connector, line)
 			}
 			log.Println("Error in " + process.Name + " console: " + scanner.Err().Error())
 		}
 	})()
 }
 
+// broadcastEvent sends an event about a change in the state of the server to console clients.
+func (process *ExposedProcess) broadcastEvent(state string) {
+	process.ConsoleLock.Lock()
+	defer process.ConsoleLock.Unlock()
+	event := ConsoleEvent{
+		Type:  "event",
+		Event: state,
+		Seq:   process.Scrollback.LastSeq(),
+		Time:  time.Now().UnixMilli(),
+	}
+	process.Clients.Range(func(client *ConsoleClient, _ string) bool {
+		client.Send(event)
+		return true
+	})
+}
+
+// RemoveProcess removes a process from the connector, then disconnects its console clients after
+// a delay, so they can receive any remaining console output.
+func (connector *Connector) RemoveProcess(name string) {
+	if process, loaded := connector.Processes.LoadAndDelete(name); loaded {
+		<-time.After(5 * time.Second)
+		process.Clients.Range(func(client *ConsoleClient, _ string) bool {
+			client.Close()
+			return true
+		})
+		process.Clients.Clear()
+		process.ConsoleLog.Close()
+		process.RCONConnection.Close()
+		close(process.InputClosed)
+	}
+}
+
 func httpError(w http.ResponseWriter, errMsg string, code int) {
 	w.Header().Set("content-type", "application/json")
 	errorJson, err := json.Marshal(struct {
@@ -279,6 +488,7 @@ func writeJsonStructRes(w http.ResponseWriter, resp interface{}) error {
 
 // UpdateConfig updates the connector with the new Config passed in arguments.
 func (connector *Connector) UpdateConfig(config *Config) {
+	connector.Config.Store(config)
 	// Update logged actions.
 	func() {
 		connector.Logger.Lock.Lock()
@@ -324,6 +534,12 @@ func (connector *Connector) UpdateConfig(config *Config) {
 			value.Process.ServerConfigMutex.Lock()
 			defer value.Process.ServerConfigMutex.Unlock()
 			value.Process.ServerConfig = serverConfig
+			value.Scrollback.SetLimits(serverConfig.Console.ScrollbackLines, serverConfig.Console.ScrollbackBytes)
+			value.ConsoleLog.SetConfig(config.Logging, key)
+			value.History.SetConfig(config.Logging, key)
+			if serverConfig.RCON == nil {
+				value.RCONConnection.Close()
+			}
 			value.ToDelete.Swap(false)
 		} else {
 			value.ToDelete.Swap(true)
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default limits of the console scrollback kept in memory for each server.
+const (
+	defaultScrollbackLines = 2500
+	defaultScrollbackBytes = 4 << 20
+)
+
+// ConsoleLine is a line of console output, numbered in the order it was output by the server.
+type ConsoleLine struct {
+	Seq  uint64 `json:"seq,omitempty"` // Zero for lines read from console logs.
+	Time int64  `json:"time"`          // Unix time in milliseconds.
+	Text string `json:"text"`
+	// Plain, HTML and Fields are only set if console parsing is enabled for the server.
+	Plain  string             `json:"plain,omitempty"` // Text without ANSI escape codes.
+	HTML   string             `json:"html,omitempty"`  // HTML-escaped text, with ANSI styles as spans.
+	Fields *ConsoleLineFields `json:"fields,omitempty"`
+}
+
+// size returns the approximate number of bytes used by the line.
+func (l ConsoleLine) size() int {
+	size := len(l.Text) + len(l.Plain) + len(l.HTML)
+	if l.Fields != nil {
+		size += len(l.Fields.Time) + len(l.Fields.Level) + len(l.Fields.Thread) +
+			len(l.Fields.Logger) + len(l.Fields.Message)
+	}
+	return size
+}
+
+// ConsoleEvent is sent to console-v3 clients when the state of the server changes. Seq is the
+// sequence number of the last line of output before the event.
+type ConsoleEvent struct {
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Seq   uint64 `json:"seq"`
+	Time  int64  `json:"time"`
+}
+
+// ConsoleBuffer is a ring buffer of console output, which is limited to a number of lines and
+// bytes. When either limit is exceeded, the oldest lines are dropped.
+type ConsoleBuffer struct {
+	mutex    sync.RWMutex
+	lines    []ConsoleLine
+	start    int
+	count    int
+	size     int
+	lastSeq  uint64
+	maxLines int
+	maxBytes int
+}
+
+// NewConsoleBuffer creates a ConsoleBuffer with the given limits, using defaults for zero values.
+//
+// Sequence numbers start after the time the buffer was created in microseconds, so that they keep
+// increasing when Octyne restarts, as long as less than a million lines are output per second on
+// average. Clients resuming with a sequence number from before a restart then receive all the
+// output, instead of missing output until the sequence numbers catch up. Microseconds keep the
+// sequence numbers below 2^53, so they can be represented exactly in JavaScript.
+func NewConsoleBuffer(maxLines int, maxBytes int) *ConsoleBuffer {
+	buffer := &ConsoleBuffer{lastSeq: uint64(max(time.Now().UnixMicro(), 0))}
+	buffer.SetLimits(maxLines, maxBytes)
+	return buffer
+}
+
+// SetLimits changes the limits of the buffer, using defaults for zero values.
+func (b *ConsoleBuffer) SetLimits(maxLines int, maxBytes int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if maxLines <= 0 {
+		maxLines = defaultScrollbackLines
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultScrollbackBytes
+	}
+	b.maxLines = maxLines
+	b.maxBytes = maxBytes
+	b.trim(0)
+	if len(b.lines) > b.maxLines {
+		b.lines = b.ordered()
+		b.start = 0
+	}
+}
+
+// ordered returns the lines in the buffer from oldest to newest. The mutex must be held.
+func (b *ConsoleBuffer) ordered() []ConsoleLine {
+	lines := make([]ConsoleLine, b.count)
+	for i := range lines {
+		lines[i] = b.lines[(b.start+i)%len(b.lines)]
+	}
+	return lines
+}
+
+// trim drops the oldest lines until there is space for a line of the given size. The mutex must
+// be held. The newest line is never dropped, even if it exceeds the byte limit by itself.
+func (b *ConsoleBuffer) trim(size int) {
+	newLines := 0
+	if size > 0 {
+		newLines = 1
+	}
+	for b.count > 0 && (b.count+newLines > b.maxLines || b.size+size > b.maxBytes) {
+		b.size -= b.lines[b.start].size()
+		b.lines[b.start] = ConsoleLine{}
+		b.start = (b.start + 1) % len(b.lines)
+		b.count--
+	}
+	if b.count == 0 {
+		b.start = 0
+	}
+}
+
+// Append adds a line of output to the buffer, and returns it with its sequence number and time.
+func (b *ConsoleBuffer) Append(line ConsoleLine) ConsoleLine {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.lastSeq++
+	line.Seq = b.lastSeq
+	line.Time = time.Now().UnixMilli()
+	size := line.size()
+	b.trim(size)
+	if b.count < len(b.lines) {
+		b.lines[(b.start+b.count)%len(b.lines)] = line
+	} else if b.start == 0 {
+		b.lines = append(b.lines, line)
+	} else {
+		b.lines = append(b.ordered(), line)
+		b.start = 0
+	}
+	b.count++
+	b.size += size
+	return line
+}
+
+// Since returns the lines in the buffer with a sequence number greater than seq.
+func (b *ConsoleBuffer) Since(seq uint64) []ConsoleLine {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	lines := make([]ConsoleLine, 0)
+	for i := 0; i < b.count; i++ {
+		line := b.lines[(b.start+i)%len(b.lines)]
+		if line.Seq > seq {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// LastSeq returns the sequence number of the last line added to the buffer.
+func (b *ConsoleBuffer) LastSeq() uint64 {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.lastSeq
+}
+
+// String returns the contents of the buffer in the format used by console-v1 and console-v2,
+// where each line is preceded by a newline.
+func (b *ConsoleBuffer) String() string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	var builder strings.Builder
+	builder.Grow(b.size + b.count)
+	for i := 0; i < b.count; i++ {
+		builder.WriteString("\n")
+		builder.WriteString(b.lines[(b.start+i)%len(b.lines)].Text)
+	}
+	return builder.String()
+}
+
+// defaultClientQueueSize is the default number of messages queued for each console client.
+const defaultClientQueueSize = 256
+
+// ConsoleClient is a client connected to the console of a server. Messages are queued separately
+// for each client, so that slow clients don't delay console output for everyone else.
+type ConsoleClient struct {
+	Queue     chan interface{}
+	Closed    chan struct{}
+	closeOnce sync.Once
+	dropped   atomic.Int64
+}
+
+// NewConsoleClient creates a ConsoleClient which can queue up to size messages.
+func NewConsoleClient(size int) *ConsoleClient {
+	if size <= 0 {
+		size = defaultClientQueueSize
+	}
+	return &ConsoleClient{Queue: make(chan interface{}, size), Closed: make(chan struct{})}
+}
+
+// Send queues a message for the client without blocking. If the queue is full, the message is
+// dropped and false is returned.
+func (c *ConsoleClient) Send(data interface{}) bool {
+	select {
+	case <-c.Closed:
+		return true
+	case c.Queue <- data:
+		return true
+	default:
+		c.dropped.Add(1)
+		return false
+	}
+}
+
+// TakeDropped returns the number of messages dropped since it was last called.
+func (c *ConsoleClient) TakeDropped() int64 {
+	return c.dropped.Swap(0)
+}
+
+// Close signals that the client should be disconnected. It is safe to call multiple times.
+func (c *ConsoleClient) Close() {
+	c.closeOnce.Do(func() { close(c.Closed) })
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default limits on input sent to the console of a server.
+const (
+	defaultMaxLineLength   = 4096
+	defaultRateLimit       = 10
+	defaultServerRateLimit = 50
+	defaultMaxPasteLines   = 1000
+)
+
+var (
+	errInputTooLong      = errors.New("console input is too long")
+	errInputRateLimited  = errors.New("console input is sent too quickly")
+	errMultiLineInput    = errors.New("multi-line console input must be confirmed")
+	errPasteTooLong      = errors.New("paste has too many lines")
+	errPasteInProgress   = errors.New("a paste is already being sent")
+	errPasteNotConfirmed = errors.New("paste does not exist or was replaced")
+)
+
+// consoleInputError returns the message sent to console clients for an error sending input.
+func consoleInputError(err error, limits ConsoleInputConfig) string {
+	switch {
+	case errors.Is(err, errCommandNotAllowed):
+		return "You are not allowed to send this command!"
+	case errors.Is(err, errInputTooLong):
+		return "Input lines cannot be longer than " + strconv.Itoa(limits.MaxLineLength) + " bytes!"
+	case errors.Is(err, errInputRateLimited):
+		return "You are sending input too quickly!"
+	case errors.Is(err, errMultiLineInput):
+		return "Multi-line input cannot be sent here!"
+	case errors.Is(err, errPasteTooLong):
+		return "Pastes cannot be longer than " + strconv.Itoa(limits.MaxPasteLines) + " lines!"
+	case errors.Is(err, errPasteInProgress):
+		return "A paste is already being sent!"
+	case errors.Is(err, errPasteNotConfirmed):
+		return "This paste does not exist or was replaced by another paste!"
+	case errors.Is(err, errInputQueueFull):
+		return "Too much input is waiting to be sent to the server!"
+	case errors.Is(err, errInputTimeout):
+		return "The server did not accept the input in time!"
+	case errors.Is(err, errServerNotRunning):
+		return "The server is not running!"
+	}
+	return "Failed to send input!"
+}
+
+// rateLimiter is a token bucket limiting the rate of input, which allows bursts of up to one
+// second of input. The rate is passed to each call, so changes to the config apply immediately.
+type rateLimiter struct {
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// refill adds tokens for the time since the last refill. The mutex must be held.
+func (l *rateLimiter) refill(rate float64) {
+	now := time.Now()
+	burst := math.Max(rate, 1)
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens = math.Min(burst, l.tokens+now.Sub(l.last).Seconds()*rate)
+	}
+	l.last = now
+}
+
+// Delay returns how long it will take until a token is available.
+func (l *rateLimiter) Delay(rate float64) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refill(rate)
+	if l.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / rate * float64(time.Second))
+}
+
+// Take takes a token. If none are available, the limiter goes into debt.
+func (l *rateLimiter) Take(rate float64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refill(rate)
+	l.tokens--
+}
+
+// InputLimits returns the limits on input sent to the console of the server, with defaults set.
+func (process *ExposedProcess) InputLimits() ConsoleInputConfig {
+	process.ServerConfigMutex.RLock()
+	limits := process.Console.Input
+	process.ServerConfigMutex.RUnlock()
+	if limits.MaxLineLength <= 0 {
+		limits.MaxLineLength = defaultMaxLineLength
+	}
+	if limits.RateLimit <= 0 {
+		limits.RateLimit = defaultRateLimit
+	}
+	if limits.ServerRateLimit <= 0 {
+		limits.ServerRateLimit = defaultServerRateLimit
+	}
+	if limits.MaxPasteLines <= 0 {
+		limits.MaxPasteLines = defaultMaxPasteLines
+	}
+	return limits
+}
+
+// inputDelay returns how long it will take until a line of input can be sent to the server by a
+// connection, which is nil for requests which aren't part of a connection.
+func (process *ExposedProcess) inputDelay(limiter *rateLimiter, limits ConsoleInputConfig) time.Duration {
+	delay := process.InputLimiter.Delay(limits.ServerRateLimit)
+	if limiter != nil {
+		delay = max(delay, limiter.Delay(limits.RateLimit))
+	}
+	return delay
+}
+
+// takeInput takes a token from the rate limiters of the server and connection for a line of input.
+func (process *ExposedProcess) takeInput(limiter *rateLimiter, limits ConsoleInputConfig) {
+	process.InputLimiter.Take(limits.ServerRateLimit)
+	if limiter != nil {
+		limiter.Take(limits.RateLimit)
+	}
+}
+
+// splitConsoleInput splits input into lines, ignoring a single trailing newline.
+func splitConsoleInput(input string) []string {
+	input = strings.TrimSuffix(strings.TrimSuffix(input, "\n"), "\r")
+	lines := strings.Split(input, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// checkConsoleInput checks a single line of input against the limits of the server. The connection
+// limiter may be nil. No tokens are taken here, recordConsoleInput takes them once the input has
+// been allowed by the command policies, so rejected input doesn't use up the rate limit.
+func checkConsoleInput(process *ExposedProcess, limiter *rateLimiter, command string) error {
+	limits := process.InputLimits()
+	if strings.ContainsAny(command, "\r\n") {
+		return errMultiLineInput
+	} else if len(command) > limits.MaxLineLength {
+		return errInputTooLong
+	} else if process.inputDelay(limiter, limits) > 0 {
+		return errInputRateLimited
+	}
+	return nil
+}
+
+// consoleInput limits the input sent by a console connection, and holds multi-line pastes until
+// they are confirmed by the client.
+type consoleInput struct {
+	limiter rateLimiter
+	mutex   sync.Mutex
+	pasteID uint64
+	paste   []string // The paste waiting for confirmation.
+	pasting bool
+}
+
+// Paste holds lines of input until they are confirmed, replacing any paste which wasn't confirmed
+// yet, and returns the ID used to confirm them.
+func (i *consoleInput) Paste(process *ExposedProcess, lines []string) (uint64, error) {
+	limits := process.InputLimits()
+	if len(lines) > limits.MaxPasteLines {
+		return 0, errPasteTooLong
+	}
+	for _, line := range lines {
+		if len(line) > limits.MaxLineLength {
+			return 0, errInputTooLong
+		}
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.pasteID++
+	i.paste = lines
+	return i.pasteID, nil
+}
+
+// Confirm sends the paste with the given ID to the server line by line, as quickly as the rate
+// limits allow. Sending stops if a line fails to be sent, or the connection is closed.
+func (i *consoleInput) Confirm(
+	process *ExposedProcess, id string, closed <-chan struct{},
+	send func(command string) error, onError func(err error),
+) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.pasting {
+		return errPasteInProgress
+	} else if i.paste == nil || id != strconv.FormatUint(i.pasteID, 10) {
+		return errPasteNotConfirmed
+	}
+	lines := i.paste
+	i.paste = nil
+	i.pasting = true
+	go (func() {
+		defer (func() {
+			i.mutex.Lock()
+			defer i.mutex.Unlock()
+			i.pasting = false
+		})()
+		for _, line := range lines {
+			if !process.waitForInput(&i.limiter, closed) {
+				return
+			} else if err := send(line); err != nil {
+				onError(err)
+				return
+			}
+		}
+	})()
+	return nil
+}
+
+// waitForInput waits until a line of input can be sent to the server by a connection, returning
+// false if the connection was closed first. Like checkConsoleInput, no tokens are taken.
+func (process *ExposedProcess) waitForInput(limiter *rateLimiter, closed <-chan struct{}) bool {
+	for {
+		delay := process.inputDelay(limiter, process.InputLimits())
+		if delay <= 0 {
+			return true
+		}
+		select {
+		case <-time.After(delay):
+		case <-closed:
+			return false
+		}
+	}
+}
+
+// consoleInputStatus returns the HTTP status code for an error sending input over HTTP.
+func consoleInputStatus(err error) int {
+	switch {
+	case errors.Is(err, errCommandNotAllowed):
+		return http.StatusForbidden
+	case errors.Is(err, errInputRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errInputQueueFull):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errInputTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, errServerNotRunning):
+		return http.StatusConflict
+	case errors.Is(err, errInputTooLong), errors.Is(err, errMultiLineInput):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// consoleLogTimeLayout is the layout of the timestamp preceding each line in console log files.
+const consoleLogTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// consoleLogQueueSize is the number of lines which can be waiting to be written to a console log.
+const consoleLogQueueSize = 4096
+
+var errConsoleLogQueueFull = errors.New("console log queue is full, lines are being dropped")
+
+// ConsoleLogInfo is info about a console log file of a server.
+type ConsoleLogInfo struct {
+	File string `json:"file"`
+	Size int64  `json:"size"`
+	Time int64  `json:"time"`
+}
+
+// ConsoleLog writes the console output of a server to log files, which are rotated and compressed
+// by lumberjack when they grow too large or get too old. Lines are queued and written in the
+// background, so a slow disk doesn't hold up console output.
+type ConsoleLog struct {
+	mutex       sync.Mutex
+	name        string
+	logger      *lumberjack.Logger
+	lastRotated time.Time
+	queue       chan ConsoleLine
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+// consoleLogDirectory returns the folder containing the console logs of a server.
+func consoleLogDirectory(config LoggingConfig, name string) string {
+	return filepath.Join(config.Path, "servers", name)
+}
+
+// NewConsoleLog creates a ConsoleLog for the server with the given name. If console logging is
+// disabled, all writes to the returned ConsoleLog are discarded.
+func NewConsoleLog(config LoggingConfig, name string) *ConsoleLog {
+	consoleLog := &ConsoleLog{
+		lastRotated: time.Now(),
+		queue:       make(chan ConsoleLine, consoleLogQueueSize),
+		closed:      make(chan struct{}),
+	}
+	consoleLog.SetConfig(config, name)
+	go consoleLog.writeQueue()
+	return consoleLog
+}
+
+// SetConfig updates the file and rotation settings of the log.
+func (l *ConsoleLog) SetConfig(config LoggingConfig, name string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.name = name
+	if !config.Enabled || !config.Console.Enabled {
+		if l.logger != nil {
+			l.logger.Close()
+			l.logger = nil
+		}
+		return
+	}
+	filename := filepath.Join(consoleLogDirectory(config, name), "console.log")
+	if l.logger != nil && l.logger.Filename != filename {
+		l.logger.Close()
+		l.logger = nil
+	}
+	if l.logger == nil {
+		l.logger = &lumberjack.Logger{Filename: filename, LocalTime: true}
+	}
+	l.logger.MaxSize = config.Console.MaxSize
+	l.logger.MaxBackups = config.Console.MaxFiles
+	l.logger.MaxAge = config.Console.MaxAge
+	l.logger.Compress = config.Console.Compress
+}
+
+// Queue queues a line of console output to be written to the log. If the queue is full, the line
+// is dropped and an error is returned.
+func (l *ConsoleLog) Queue(line ConsoleLine) error {
+	l.mutex.Lock()
+	enabled := l.logger != nil
+	l.mutex.Unlock()
+	if !enabled {
+		return nil
+	}
+	select {
+	case l.queue <- line:
+		return nil
+	default:
+		return errConsoleLogQueueFull
+	}
+}
+
+// writeQueue writes queued lines to the log until it is closed.
+func (l *ConsoleLog) writeQueue() {
+	logFailed := false
+	for {
+		select {
+		case line := <-l.queue:
+			// Only log the first error, until writing to the console log succeeds again.
+			if err := l.write(line); err != nil && !logFailed {
+				log.Println("Failed to write to console log of server "+l.name+"!", err)
+				logFailed = true
+			} else if err == nil {
+				logFailed = false
+			}
+		case <-l.closed:
+			return
+		}
+	}
+}
+
+// write writes a line of console output to the log, preceded by the time it was output.
+func (l *ConsoleLog) write(line ConsoleLine) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.logger == nil {
+		return nil
+	}
+	timestamp := time.UnixMilli(line.Time).Format(consoleLogTimeLayout)
+	_, err := l.logger.Write([]byte(timestamp + " " + line.Text + "\n"))
+	return err
+}
+
+// LastRotated returns when the log was last rotated, or when Octyne started if it wasn't.
+func (l *ConsoleLog) LastRotated() time.Time {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.lastRotated
+}
+
+// Rotate archives the current log file and starts a new one.
+func (l *ConsoleLog) Rotate() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lastRotated = time.Now()
+	if l.logger == nil {
+		return nil
+	}
+	return l.logger.Rotate()
+}
+
+// Close stops writing to the log and closes the current log file. Queued lines are discarded.
+func (l *ConsoleLog) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.logger == nil {
+		return nil
+	}
+	return l.logger.Close()
+}
+
+// isValidConsoleLogFile checks whether a file name could be a console log created by Octyne.
+func isValidConsoleLogFile(file string) bool {
+	return strings.HasPrefix(file, "console") && filepath.Base(file) == file &&
+		(strings.HasSuffix(file, ".log") || strings.HasSuffix(file, ".log.gz"))
+}
+
+// listConsoleLogs lists the console log files in a folder, sorted from newest to oldest.
+func listConsoleLogs(dir string) ([]ConsoleLogInfo, error) {
+	logs := make([]ConsoleLogInfo, 0)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return logs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !isValidConsoleLogFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, ConsoleLogInfo{
+			File: entry.Name(),
+			Size: info.Size(),
+			Time: info.ModTime().Unix(),
+		})
+	}
+	slices.SortFunc(logs, func(a, b ConsoleLogInfo) int { return int(b.Time - a.Time) })
+	return logs, nil
+}
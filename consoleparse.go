@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// ConsoleLineFields are the fields parsed from a line of console output. Fields which aren't
+// present in the line, or in the pattern it matched, are empty.
+type ConsoleLineFields struct {
+	Time    string `json:"time,omitempty"`
+	Level   string `json:"level,omitempty"`
+	Thread  string `json:"thread,omitempty"`
+	Logger  string `json:"logger,omitempty"`
+	Message string `json:"message"`
+}
+
+// defaultConsolePatterns match the log formats of vanilla/Forge/Fabric, Paper/Velocity and
+// BungeeCord respectively.
+var defaultConsolePatterns = []string{
+	`^\[(?P<time>[^\]]+)\] \[(?P<thread>[^\]]*)/(?P<level>[A-Z]+)\](?: \[(?P<logger>[^\]]+)\])?: (?P<message>.*)$`,
+	`^\[(?P<time>\d{2}:\d{2}:\d{2}) (?P<level>[A-Z]+)\](?: \[(?P<logger>[^\]]+)\])?: (?P<message>.*)$`,
+	`^(?P<time>\d{2}:\d{2}:\d{2}) \[(?P<level>[A-Z]+)\] (?:\[(?P<logger>[^\]]+)\] )?(?P<message>.*)$`,
+}
+
+// parseConsoleLine sets the plain text, HTML and fields of a line of console output. The fields
+// are parsed from the plain text with the first matching pattern, using the default patterns if
+// none are given. Fields is nil if no pattern matches.
+func parseConsoleLine(line *ConsoleLine, patterns []string) {
+	line.Plain, line.HTML = convertANSI(line.Text)
+	if len(patterns) == 0 {
+		patterns = defaultConsolePatterns
+	}
+	for _, pattern := range patterns {
+		regex := compilePattern(pattern)
+		if regex == nil {
+			continue
+		}
+		match := regex.FindStringSubmatch(line.Plain)
+		if match == nil {
+			continue
+		}
+		fields := &ConsoleLineFields{}
+		for i, name := range regex.SubexpNames() {
+			switch name {
+			case "time":
+				fields.Time = match[i]
+			case "level":
+				fields.Level = match[i]
+			case "thread":
+				fields.Thread = match[i]
+			case "logger":
+				fields.Logger = match[i]
+			case "message":
+				fields.Message = match[i]
+			}
+		}
+		line.Fields = fields
+		return
+	}
+}
+
+// ansiColours are the names of the standard ANSI colours, used in the CSS classes of HTML output.
+var ansiColours = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// ansiStyle is the style of text set by ANSI SGR escape codes.
+type ansiStyle struct {
+	bold, dim, italic, underline, strikethrough bool
+	// Colours are either CSS classes for the standard colours, or hex codes for extended colours.
+	fg, bg string
+}
+
+// span returns the opening span tag for the style, or an empty string if the style is the default.
+func (s ansiStyle) span() string {
+	var classes []string
+	var styles []string
+	for _, attribute := range []struct {
+		set  bool
+		name string
+	}{
+		{s.bold, "ansi-bold"}, {s.dim, "ansi-dim"}, {s.italic, "ansi-italic"},
+		{s.underline, "ansi-underline"}, {s.strikethrough, "ansi-strikethrough"},
+	} {
+		if attribute.set {
+			classes = append(classes, attribute.name)
+		}
+	}
+	if strings.HasPrefix(s.fg, "#") {
+		styles = append(styles, "color:"+s.fg)
+	} else if s.fg != "" {
+		classes = append(classes, "ansi-"+s.fg)
+	}
+	if strings.HasPrefix(s.bg, "#") {
+		styles = append(styles, "background-color:"+s.bg)
+	} else if s.bg != "" {
+		classes = append(classes, "ansi-bg-"+s.bg)
+	}
+	if len(classes) == 0 && len(styles) == 0 {
+		return ""
+	}
+	span := "<span"
+	if len(classes) > 0 {
+		span += ` class="` + strings.Join(classes, " ") + `"`
+	}
+	if len(styles) > 0 {
+		span += ` style="` + strings.Join(styles, ";") + `"`
+	}
+	return span + ">"
+}
+
+// apply updates the style with the parameters of an SGR escape code.
+func (s *ansiStyle) apply(params []int) {
+	if len(params) == 0 {
+		params = []int{0}
+	}
+	for i := 0; i < len(params); i++ {
+		switch param := params[i]; {
+		case param == 0:
+			*s = ansiStyle{}
+		case param == 1:
+			s.bold = true
+		case param == 2:
+			s.dim = true
+		case param == 3:
+			s.italic = true
+		case param == 4:
+			s.underline = true
+		case param == 9:
+			s.strikethrough = true
+		case param == 22:
+			s.bold, s.dim = false, false
+		case param == 23:
+			s.italic = false
+		case param == 24:
+			s.underline = false
+		case param == 29:
+			s.strikethrough = false
+		case param >= 30 && param <= 37:
+			s.fg = ansiColours[param-30]
+		case param >= 90 && param <= 97:
+			s.fg = "bright-" + ansiColours[param-90]
+		case param == 39:
+			s.fg = ""
+		case param >= 40 && param <= 47:
+			s.bg = ansiColours[param-40]
+		case param >= 100 && param <= 107:
+			s.bg = "bright-" + ansiColours[param-100]
+		case param == 49:
+			s.bg = ""
+		case param == 38 || param == 48:
+			colour, used := extendedANSIColour(params[i+1:])
+			i += used
+			if param == 38 {
+				s.fg = colour
+			} else {
+				s.bg = colour
+			}
+		}
+	}
+}
+
+// extendedANSIColour parses the parameters of a 256 colour or RGB colour following 38 or 48, and
+// returns the colour along with the number of parameters used. Invalid colours are ignored.
+func extendedANSIColour(params []int) (string, int) {
+	if len(params) >= 2 && params[0] == 5 {
+		n := params[1]
+		switch {
+		case n < 0 || n > 255:
+			return "", 2
+		case n < 8:
+			return ansiColours[n], 2
+		case n < 16:
+			return "bright-" + ansiColours[n-8], 2
+		case n < 232:
+			n -= 16
+			level := func(v int) int {
+				if v == 0 {
+					return 0
+				}
+				return 55 + v*40
+			}
+			return fmt.Sprintf("#%02x%02x%02x", level(n/36), level(n/6%6), level(n%6)), 2
+		default:
+			grey := 8 + (n-232)*10
+			return fmt.Sprintf("#%02x%02x%02x", grey, grey, grey), 2
+		}
+	} else if len(params) >= 4 && params[0] == 2 {
+		for _, value := range params[1:4] {
+			if value < 0 || value > 255 {
+				return "", 4
+			}
+		}
+		return fmt.Sprintf("#%02x%02x%02x", params[1], params[2], params[3]), 4
+	}
+	return "", len(params)
+}
+
+// convertANSI returns the text with ANSI escape codes removed, and the text as HTML, where it is
+// escaped and its colours and styles are converted to spans with CSS classes, e.g. ansi-bold,
+// ansi-red and ansi-bg-bright-blue. Extended colours use inline styles instead.
+func convertANSI(text string) (string, string) {
+	if !strings.Contains(text, "\x1b") {
+		return text, html.EscapeString(text)
+	}
+	var plain, output strings.Builder
+	style := ansiStyle{}
+	open := false
+	for i := 0; i < len(text); {
+		next := strings.IndexByte(text[i:], '\x1b')
+		if next == -1 {
+			next = len(text)
+		} else {
+			next += i
+		}
+		if next > i {
+			plain.WriteString(text[i:next])
+			if !open {
+				if span := style.span(); span != "" {
+					output.WriteString(span)
+					open = true
+				}
+			}
+			output.WriteString(html.EscapeString(text[i:next]))
+		}
+		if next >= len(text) {
+			break
+		}
+		i = next + 1
+		if i >= len(text) {
+			break
+		}
+		switch text[i] {
+		case '[': // CSI sequence, only SGR sequences ending in m are used.
+			end := i + 1
+			for end < len(text) && (text[end] < 0x40 || text[end] > 0x7e) {
+				end++
+			}
+			if end < len(text) && text[end] == 'm' {
+				var params []int
+				for _, param := range strings.Split(text[i+1:end], ";") {
+					value, _ := strconv.Atoi(param)
+					params = append(params, value)
+				}
+				style.apply(params)
+				if open {
+					output.WriteString("</span>")
+					open = false
+				}
+			}
+			i = end + 1
+		case ']': // OSC sequence, terminated by BEL or ST.
+			end := i + 1
+			for end < len(text) && text[end] != '\a' && !strings.HasPrefix(text[end:], "\x1b\\") {
+				end++
+			}
+			if end < len(text) && text[end] == '\x1b' {
+				end++
+			}
+			i = end + 1
+		default:
+			i++
+		}
+	}
+	if open {
+		output.WriteString("</span>")
+	}
+	return plain.String(), output.String()
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestConvertANSI(t *testing.T) {
+	tests := []struct {
+		input string
+		plain string
+		html  string
+	}{
+		{"hello <world>", "hello <world>", "hello &lt;world&gt;"},
+		{"\x1b[1;31mred\x1b[0m text", "red text", `<span class="ansi-bold ansi-red">red</span> text`},
+		{"\x1b[38;5;196mhi", "hi", `<span style="color:#ff0000">hi</span>`},
+		{"\x1b[38;2;1;2;3mhi", "hi", `<span style="color:#010203">hi</span>`},
+		// Malformed and out of range sequences must be ignored instead of panicking.
+		{"\x1b[38;5;-1mhi", "hi", "hi"},
+		{"\x1b[48;5;256mhi", "hi", "hi"},
+		{"\x1b[38;2;-1;0;300mhi", "hi", "hi"},
+		{"\x1b[38;5mhi", "hi", "hi"},
+		{"\x1b[38;2;1mhi", "hi", "hi"},
+		{"\x1b[-5;-38mhi", "hi", "hi"},
+		{"\x1b[99999999999999999999mhi", "hi", "hi"},
+		{"\x1b[", "", ""},
+		{"hi\x1b", "hi", "hi"},
+	}
+	for _, test := range tests {
+		plain, html := convertANSI(test.input)
+		if plain != test.plain || html != test.html {
+			t.Errorf("convertANSI(%q) = %q, %q; want %q, %q", test.input, plain, html, test.plain, test.html)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ConsoleSearchResult is a line of console output matching a search, along with the lines
+// surrounding it.
+type ConsoleSearchResult struct {
+	ConsoleLine
+	Before []ConsoleLine `json:"before,omitempty"`
+	After  []ConsoleLine `json:"after,omitempty"`
+}
+
+// ConsoleSearch searches lines of console output, which must be added in the order they were
+// output. Only the last Limit matches are kept. Lines are matched without ANSI escape codes if they
+// have been parsed.
+type ConsoleSearch struct {
+	Match     func(text string) bool
+	From, To  int64    // Unix time in milliseconds, zero values are ignored.
+	Levels    []string // Only lines parsed with one of these levels match, if not empty.
+	Limit     int
+	Context   int
+	Truncated bool
+	// Parsing is used to parse lines read from console log files.
+	Parsing ConsoleParsingConfig
+
+	results []*ConsoleSearchResult
+	head    []ConsoleLine // The first Context lines searched.
+	before  []ConsoleLine
+	pending []*ConsoleSearchResult // Results which are still waiting for lines after them.
+}
+
+// child returns an empty search with the same parameters.
+func (s *ConsoleSearch) child() *ConsoleSearch {
+	return &ConsoleSearch{
+		Match: s.Match, From: s.From, To: s.To, Levels: s.Levels, Limit: s.Limit, Context: s.Context,
+		Parsing: s.Parsing,
+	}
+}
+
+// Add searches a line of console output.
+func (s *ConsoleSearch) Add(line ConsoleLine) {
+	s.addAfter(line)
+	if len(s.head) < s.Context {
+		s.head = append(s.head, line)
+	}
+	text := line.Text
+	if line.Plain != "" {
+		text = line.Plain
+	}
+	if (s.From == 0 || line.Time >= s.From) && (s.To == 0 || line.Time <= s.To) &&
+		s.matchesLevel(line) && s.Match(text) {
+		result := &ConsoleSearchResult{ConsoleLine: line, Before: slices.Clone(s.before)}
+		if len(s.results) >= s.Limit {
+			s.results = s.results[1:]
+			s.Truncated = true
+		}
+		s.results = append(s.results, result)
+		if s.Context > 0 {
+			s.pending = append(s.pending, result)
+		}
+	}
+	if s.Context > 0 {
+		if len(s.before) >= s.Context {
+			s.before = s.before[1:]
+		}
+		s.before = append(s.before, line)
+	}
+}
+
+// addAfter adds a line after the results which are still waiting for lines after them.
+func (s *ConsoleSearch) addAfter(line ConsoleLine) {
+	for len(s.pending) > 0 && len(s.pending[0].After) >= s.Context {
+		s.pending = s.pending[1:]
+	}
+	for _, result := range s.pending {
+		result.After = append(result.After, line)
+	}
+}
+
+// waiting checks whether any results are still waiting for lines after them.
+func (s *ConsoleSearch) waiting() bool {
+	return len(s.pending) > 0 && len(s.pending[len(s.pending)-1].After) < s.Context
+}
+
+// matchesLevel checks whether the level of a line is one of the searched levels.
+func (s *ConsoleSearch) matchesLevel(line ConsoleLine) bool {
+	if len(s.Levels) == 0 {
+		return true
+	} else if line.Fields == nil {
+		return false
+	}
+	for _, level := range s.Levels {
+		if strings.EqualFold(level, line.Fields.Level) {
+			return true
+		}
+	}
+	return false
+}
+
+// Results returns the matches found so far, from oldest to newest.
+func (s *ConsoleSearch) Results() []*ConsoleSearchResult {
+	if s.results == nil {
+		return make([]*ConsoleSearchResult, 0)
+	}
+	return s.results
+}
+
+// SearchFile searches the lines of a console log file which were output before the given time.
+// Lines which weren't written by Octyne (e.g. lines without a timestamp) are skipped. Searching
+// stops once lines are newer than To, and no results are waiting for lines after them.
+func (s *ConsoleSearch) SearchFile(name string, before int64) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if strings.HasSuffix(name, ".gz") {
+		compressionReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer compressionReader.Close()
+		reader = compressionReader
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024+len(consoleLogTimeLayout)+1)
+	for scanner.Scan() {
+		timestamp, text, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		parsed, err := time.Parse(consoleLogTimeLayout, timestamp)
+		if err != nil {
+			continue
+		} else if before != 0 && parsed.UnixMilli() >= before {
+			break
+		} else if s.To != 0 && parsed.UnixMilli() > s.To && !s.waiting() {
+			break
+		}
+		line := ConsoleLine{Time: parsed.UnixMilli(), Text: text}
+		if s.Parsing.Enabled {
+			parseConsoleLine(&line, s.Parsing.Patterns)
+		}
+		s.Add(line)
+	}
+	return scanner.Err()
+}
+
+// searchConsole searches the console logs of a server in dir, followed by its scrollback. Console
+// logs are only searched up to the oldest line in the scrollback, since the scrollback contains
+// the same lines.
+//
+// The scrollback and console logs are searched from newest to oldest, so that older console logs
+// don't have to be read once Limit matches are found, in which case the search is truncated.
+// Console logs which were written to entirely outside the searched time range are skipped. Each
+// is searched separately, and the lines around matches at the boundaries between them are joined.
+func searchConsole(search *ConsoleSearch, dir string, scrollback []ConsoleLine) error {
+	var oldest int64
+	if len(scrollback) > 0 {
+		oldest = scrollback[0].Time
+	}
+	logs, err := listConsoleLogs(dir)
+	if err != nil {
+		return err
+	}
+	// Rotated logs are named after the time they were rotated at, so sorting them by name sorts them
+	// from oldest to newest, with console.log being the last one.
+	slices.SortFunc(logs, func(a, b ConsoleLogInfo) int {
+		if a.File == "console.log" {
+			return 1
+		} else if b.File == "console.log" {
+			return -1
+		}
+		return strings.Compare(a.File, b.File)
+	})
+	newer := search.child()
+	for _, line := range scrollback {
+		newer.Add(line)
+	}
+	results, truncated := newer.results, newer.Truncated
+	for i := len(logs) - 1; i >= 0; i-- {
+		// A log contains the lines output after the previous log was last written to.
+		var start int64
+		if i > 0 {
+			start = logs[i-1].Time * 1000
+		}
+		if (search.From != 0 && (logs[i].Time+1)*1000 <= search.From) || (search.To != 0 && start > search.To) {
+			newer = nil // The lines around matches can't be joined across skipped logs.
+			continue
+		} else if len(results) >= search.Limit {
+			truncated = true
+			break
+		}
+		older := search.child()
+		err := older.SearchFile(filepath.Join(dir, logs[i].File), oldest)
+		if err != nil {
+			return err
+		}
+		if newer != nil {
+			for _, line := range newer.head {
+				older.addAfter(line)
+			}
+			for _, result := range newer.results {
+				if missing := search.Context - len(result.Before); missing > 0 {
+					tail := older.before[max(0, len(older.before)-missing):]
+					result.Before = append(slices.Clone(tail), result.Before...)
+				}
+			}
+		}
+		results, truncated = append(older.results, results...), truncated || older.Truncated
+		newer = older
+	}
+	if len(results) > search.Limit {
+		results, truncated = results[len(results)-search.Limit:], true
+	}
+	search.results, search.Truncated = results, truncated
+	return nil
+}
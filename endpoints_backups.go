@@ -0,0 +1,378 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/retrixe/octyne/snapshot"
+)
+
+// handleBackupError responds to the client with an appropriate error for backup operations.
+func handleBackupError(w http.ResponseWriter, process *ExposedProcess, action string, err error) {
+	if errors.Is(err, errBackupNotFound) {
+		httpError(w, "This backup does not exist!", http.StatusNotFound)
+	} else if errors.Is(err, snapshot.ErrSnapshotNotFound) {
+		httpError(w, "This snapshot does not exist!", http.StatusNotFound)
+	} else if errors.Is(err, errSnapshotsDisabled) {
+		httpError(w, "Snapshots are not configured for this server!", http.StatusBadRequest)
+	} else if errors.Is(err, errBackupInProgress) {
+		httpError(w, "A backup or restore of this server is already in progress!", http.StatusConflict)
+	} else if errors.Is(err, errServerRunning) {
+		httpError(w, "The server must be stopped before it can be restored!", http.StatusConflict)
+	} else {
+		log.Println("An error occurred when "+action, "("+process.Name+")", err)
+		httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+	}
+}
+
+// isValidBackupFile checks if a file name refers to a file directly inside a backup folder.
+func isValidBackupFile(file string) bool {
+	return file != "" && file != "." && file != ".." && filepath.Base(file) == file
+}
+
+// GET /server/{id}/backups?name=name
+// POST /server/{id}/backups?name=name
+// DELETE /server/{id}/backups?name=name&file=file
+func backupsEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	// Check with authenticator.
+	var perm string
+	switch r.Method {
+	case "GET":
+		perm = "server<" + id + ">.backups.view"
+	case "POST":
+		perm = "server<" + id + ">.backups.create"
+	case "DELETE":
+		perm = "server<" + id + ">.backups.delete"
+	default:
+		httpError(w, "Only GET, POST and DELETE are allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, perm)
+	if user == "" || !hasPerm {
+		return
+	}
+	// Get the process being accessed.
+	process, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		backupsEndpointGet(w, r, process)
+	case "POST":
+		backupsEndpointPost(connector, w, r, process, id, user)
+	case "DELETE":
+		backupsEndpointDelete(connector, w, r, process, id, user)
+	}
+}
+
+func backupsEndpointGet(w http.ResponseWriter, r *http.Request, process *ExposedProcess) {
+	process.ServerConfigMutex.RLock()
+	names := make([]string, 0, len(process.Backups))
+	for name := range process.Backups {
+		if r.URL.Query().Get("name") == "" || r.URL.Query().Get("name") == name {
+			names = append(names, name)
+		}
+	}
+	process.ServerConfigMutex.RUnlock()
+	if len(names) == 0 && r.URL.Query().Get("name") != "" {
+		httpError(w, "This backup does not exist!", http.StatusNotFound)
+		return
+	}
+	res := make(map[string][]BackupInfo)
+	for _, name := range names {
+		_, dir, err := process.getBackupConfig(name)
+		if err != nil {
+			handleBackupError(w, process, "listing backups", err)
+			return
+		}
+		res[name], err = listBackups(dir)
+		if err != nil {
+			handleBackupError(w, process, "listing backups", err)
+			return
+		}
+	}
+	writeJsonStructRes(w, map[string]interface{}{"backups": res}) // skipcq GSC-G104
+}
+
+func backupsEndpointPost(connector *Connector, w http.ResponseWriter, r *http.Request,
+	process *ExposedProcess, id string, user string) {
+	name := r.URL.Query().Get("name")
+	backup, err := process.CreateBackup(name)
+	if err != nil {
+		handleBackupError(w, process, "creating backup "+name, err)
+		return
+	}
+	connector.Info("server.backups.create", "ip", GetIP(r), "user", user, "server", id,
+		"name", name, "file", backup.File)
+	writeJsonStructRes(w, map[string]interface{}{"success": true, "backup": backup}) // skipcq GSC-G104
+}
+
+func backupsEndpointDelete(connector *Connector, w http.ResponseWriter, r *http.Request,
+	process *ExposedProcess, id string, user string) {
+	name := r.URL.Query().Get("name")
+	file := r.URL.Query().Get("file")
+	if !isValidBackupFile(file) {
+		httpError(w, "Invalid backup file!", http.StatusBadRequest)
+		return
+	}
+	_, dir, err := process.getBackupConfig(name)
+	if err != nil {
+		handleBackupError(w, process, "deleting backup "+name, err)
+		return
+	}
+	err = os.Remove(filepath.Join(dir, file))
+	if err != nil && os.IsNotExist(err) {
+		httpError(w, "This backup does not exist!", http.StatusNotFound)
+		return
+	} else if err != nil {
+		handleBackupError(w, process, "deleting backup "+name, err)
+		return
+	}
+	connector.Info("server.backups.delete", "ip", GetIP(r), "user", user, "server", id,
+		"name", name, "file", file)
+	writeJsonStringRes(w, "{\"success\":true}")
+}
+
+// GET /server/{id}/backups/download?name=name&file=file&ticket=ticket
+func backupDownloadEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method != "GET" && r.Method != "HEAD" {
+		httpError(w, "Only GET and HEAD are allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	// Check with authenticator.
+	user, hasPerm := connector.validateTicketWithPermAndReject(w, r, "server<"+id+">.backups.download")
+	if user == "" || !hasPerm {
+		return
+	}
+	// Get the process being accessed.
+	process, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	file := r.URL.Query().Get("file")
+	if !isValidBackupFile(file) {
+		httpError(w, "Invalid backup file!", http.StatusBadRequest)
+		return
+	}
+	_, dir, err := process.getBackupConfig(name)
+	if err != nil {
+		handleBackupError(w, process, "downloading backup "+name, err)
+		return
+	}
+	archive, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		httpError(w, "This backup does not exist!", http.StatusNotFound)
+		return
+	}
+	defer archive.Close()
+	stat, err := archive.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		httpError(w, "This backup does not exist!", http.StatusNotFound)
+		return
+	}
+	// Send the response.
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if r.Method == "GET" {
+		connector.Info("server.backups.download", "ip", GetIP(r), "user", user, "server", id,
+			"name", name, "file", file, "range", r.Header.Get("Range"))
+	}
+	serveDownload(w, r, archive, stat, id+"-"+name+"-"+stat.Name())
+}
+
+// POST /server/{id}/backups/restore?name=name&file=file
+func backupRestoreEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method != "POST" {
+		httpError(w, "Only POST is allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	// Check with authenticator.
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, "server<"+id+">.backups.restore")
+	if user == "" || !hasPerm {
+		return
+	}
+	// Get the process being accessed.
+	process, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	file := r.URL.Query().Get("file")
+	if !isValidBackupFile(file) {
+		httpError(w, "Invalid backup file!", http.StatusBadRequest)
+		return
+	}
+	err := process.RestoreBackup(name, file)
+	if err != nil {
+		handleBackupError(w, process, "restoring backup "+name, err)
+		return
+	}
+	connector.Info("server.backups.restore", "ip", GetIP(r), "user", user, "server", id,
+		"name", name, "file", file)
+	writeJsonStringRes(w, "{\"success\":true}")
+}
+
+// GET /server/{id}/snapshots
+// POST /server/{id}/snapshots
+// DELETE /server/{id}/snapshots?snapshot=snapshot
+func snapshotsEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	// Check with authenticator.
+	var perm string
+	switch r.Method {
+	case "GET":
+		perm = "server<" + id + ">.snapshots.view"
+	case "POST":
+		perm = "server<" + id + ">.snapshots.create"
+	case "DELETE":
+		perm = "server<" + id + ">.snapshots.delete"
+	default:
+		httpError(w, "Only GET, POST and DELETE are allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, perm)
+	if user == "" || !hasPerm {
+		return
+	}
+	// Get the process being accessed.
+	process, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		_, store, err := process.getSnapshotStore()
+		if err != nil {
+			handleBackupError(w, process, "listing snapshots", err)
+			return
+		}
+		snapshots, err := store.List(process.Name)
+		if err != nil {
+			handleBackupError(w, process, "listing snapshots", err)
+			return
+		}
+		writeJsonStructRes(w, map[string]interface{}{"snapshots": snapshots}) // skipcq GSC-G104
+	case "POST":
+		created, err := process.CreateSnapshot()
+		if err != nil {
+			handleBackupError(w, process, "creating snapshot", err)
+			return
+		}
+		connector.Info("server.snapshots.create", "ip", GetIP(r), "user", user, "server", id,
+			"snapshot", created.ID)
+		writeJsonStructRes(w, map[string]interface{}{"success": true, "snapshot": created}) // skipcq GSC-G104
+	case "DELETE":
+		_, store, err := process.getSnapshotStore()
+		if err == nil {
+			err = store.Delete(process.Name, r.URL.Query().Get("snapshot"))
+		}
+		if err != nil {
+			handleBackupError(w, process, "deleting snapshot", err)
+			return
+		}
+		connector.Info("server.snapshots.delete", "ip", GetIP(r), "user", user, "server", id,
+			"snapshot", r.URL.Query().Get("snapshot"))
+		writeJsonStringRes(w, "{\"success\":true}")
+	}
+}
+
+// POST /server/{id}/snapshots/restore?snapshot=snapshot
+// POST /server/{id}/snapshots/prune
+// POST /server/{id}/snapshots/verify?snapshot=snapshot
+func snapshotOperationEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	operation := r.PathValue("operation")
+	if r.Method != "POST" {
+		httpError(w, "Only POST is allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	// Check with authenticator.
+	var perm string
+	switch operation {
+	case "restore":
+		perm = "server<" + id + ">.snapshots.restore"
+	case "prune":
+		perm = "server<" + id + ">.snapshots.delete"
+	case "verify":
+		perm = "server<" + id + ">.snapshots.view"
+	default:
+		httpError(w, "Invalid operation requested!", http.StatusNotFound)
+		return
+	}
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, perm)
+	if user == "" || !hasPerm {
+		return
+	}
+	// Get the process being accessed.
+	process, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	snapshotId := r.URL.Query().Get("snapshot")
+	switch operation {
+	case "restore":
+		err := process.RestoreSnapshot(snapshotId)
+		if err != nil {
+			handleBackupError(w, process, "restoring snapshot "+snapshotId, err)
+			return
+		}
+		connector.Info("server.snapshots.restore", "ip", GetIP(r), "user", user, "server", id,
+			"snapshot", snapshotId)
+		writeJsonStringRes(w, "{\"success\":true}")
+	case "prune":
+		snapshots, chunks, freed, err := process.PruneSnapshots()
+		if err != nil {
+			handleBackupError(w, process, "pruning snapshots", err)
+			return
+		}
+		connector.Info("server.snapshots.prune", "ip", GetIP(r), "user", user, "server", id,
+			"deletedSnapshots", snapshots, "deletedChunks", chunks)
+		writeJsonStructRes(w, map[string]interface{}{ // skipcq GSC-G104
+			"success": true, "deletedSnapshots": snapshots, "deletedChunks": chunks, "freedSize": freed,
+		})
+	case "verify":
+		_, store, err := process.getSnapshotStore()
+		if err != nil {
+			handleBackupError(w, process, "verifying snapshots", err)
+			return
+		}
+		ids := []string{snapshotId}
+		if snapshotId == "" {
+			snapshots, err := store.List(process.Name)
+			if err != nil {
+				handleBackupError(w, process, "verifying snapshots", err)
+				return
+			}
+			ids = make([]string, 0, len(snapshots))
+			for _, snapshot := range snapshots {
+				ids = append(ids, snapshot.ID)
+			}
+		}
+		res := make(map[string][]string)
+		for _, snapshotId := range ids {
+			res[snapshotId], err = store.Verify(process.Name, snapshotId)
+			if err != nil {
+				handleBackupError(w, process, "verifying snapshot "+snapshotId, err)
+				return
+			}
+		}
+		writeJsonStructRes(w, map[string]interface{}{"problems": res}) // skipcq GSC-G104
+	}
+}
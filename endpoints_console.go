@@ -0,0 +1,787 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/retrixe/octyne/auth"
+	"github.com/retrixe/octyne/rcon"
+)
+
+// GET /server/{id}/console/logs?file=file&ticket=ticket
+func consoleLogsEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method != "GET" && r.Method != "HEAD" {
+		httpError(w, "Only GET and HEAD are allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	// Check with authenticator.
+	user, hasPerm := connector.validateTicketWithPermAndReject(w, r, "server<"+id+">.console.view")
+	if user == "" || !hasPerm {
+		return
+	}
+	// Get the process being accessed.
+	_, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	dir := consoleLogDirectory(connector.Config.Load().Logging, id)
+	file := r.URL.Query().Get("file")
+	if file == "" {
+		logs, err := listConsoleLogs(dir)
+		if err != nil {
+			log.Println("An error occurred when listing console logs of server "+id+"!", err)
+			httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+			return
+		}
+		writeJsonStructRes(w, map[string]interface{}{"logs": logs}) // skipcq GSC-G104
+		return
+	} else if !isValidConsoleLogFile(file) {
+		httpError(w, "Invalid console log file!", http.StatusBadRequest)
+		return
+	}
+	contents, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		httpError(w, "This console log does not exist!", http.StatusNotFound)
+		return
+	}
+	defer contents.Close()
+	stat, err := contents.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		httpError(w, "This console log does not exist!", http.StatusNotFound)
+		return
+	}
+	// Send the response.
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if r.Method == "GET" {
+		connector.Info("server.console.download", "ip", GetIP(r), "user", user, "server", id, "file", file,
+			"range", r.Header.Get("Range"))
+	}
+	serveDownload(w, r, contents, stat, id+"-"+stat.Name())
+}
+
+// parseIntQuery parses an optional integer query parameter into dest, which must be at least min
+// and at most max, unless max is 0. If it is invalid, the request is rejected and false is returned.
+func parseIntQuery(w http.ResponseWriter, r *http.Request, key string, dest *int64, min int64, max int64) bool {
+	if r.URL.Query().Get(key) == "" {
+		return true
+	}
+	value, err := strconv.ParseInt(r.URL.Query().Get(key), 10, 64)
+	if err != nil || value < min || (max > 0 && value > max) {
+		httpError(w, "Invalid "+key+" query parameter!", http.StatusBadRequest)
+		return false
+	}
+	*dest = value
+	return true
+}
+
+// GET /server/{id}/console/search?q=query&regex=false&level=levels&from=time&to=time&limit=100&context=0
+func consoleSearchEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method != "GET" {
+		httpError(w, "Only GET is allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	// Check with authenticator.
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, "server<"+id+">.console.view")
+	if user == "" || !hasPerm {
+		return
+	}
+	// Get the process being accessed.
+	process, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query().Get("q")
+	levels := r.URL.Query().Get("level")
+	if query == "" && levels == "" {
+		httpError(w, "No search query provided!", http.StatusBadRequest)
+		return
+	}
+	search := &ConsoleSearch{Limit: 100}
+	if levels != "" {
+		search.Levels = strings.Split(levels, ",")
+	}
+	process.ServerConfigMutex.RLock()
+	search.Parsing = process.Console.Parsing
+	process.ServerConfigMutex.RUnlock()
+	if r.URL.Query().Get("regex") == "true" {
+		regex, err := regexp.Compile(query)
+		if err != nil {
+			httpError(w, "Invalid regular expression!", http.StatusBadRequest)
+			return
+		}
+		search.Match = regex.MatchString
+	} else {
+		search.Match = func(text string) bool { return strings.Contains(text, query) }
+	}
+	limit, context := int64(search.Limit), int64(0)
+	if !parseIntQuery(w, r, "from", &search.From, 0, 0) || !parseIntQuery(w, r, "to", &search.To, 0, 0) ||
+		!parseIntQuery(w, r, "limit", &limit, 1, 1000) || !parseIntQuery(w, r, "context", &context, 0, 10) {
+		return
+	}
+	search.Limit, search.Context = int(limit), int(context)
+	dir := consoleLogDirectory(connector.Config.Load().Logging, id)
+	err := searchConsole(search, dir, process.Scrollback.Since(0))
+	if err != nil {
+		log.Println("An error occurred when searching the console of server "+id+"!", err)
+		httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+		return
+	}
+	writeJsonStructRes(w, map[string]interface{}{ // skipcq GSC-G104
+		"results":   search.Results(),
+		"truncated": search.Truncated,
+	})
+}
+
+// recordConsoleInput checks a command sent by a user against the command policies, then takes a
+// token from the rate limiters of the server and connection (which may be nil), logs it under the
+// given action and records it in the history of the server. If the command isn't allowed, the
+// attempt is logged and errCommandNotAllowed is returned instead.
+func recordConsoleInput(
+	connector *Connector, r *http.Request, process *ExposedProcess, user string, limiter *rateLimiter,
+	command string, action string,
+) error {
+	policies := connector.Config.Load().CommandPolicies
+	if allowed, policy := checkCommandPolicies(policies, user, process.Name, command); !allowed {
+		connector.Info("server.console.inputRejected", "ip", GetIP(r), "user", user, "server", process.Name,
+			"input", command, "policy", policy)
+		return errCommandNotAllowed
+	}
+	process.takeInput(limiter, process.InputLimits())
+	connector.Info(action, "ip", GetIP(r), "user", user, "server", process.Name, "input", command)
+	if err := process.History.Add(user, GetIP(r), command); err != nil {
+		log.Println("Failed to record command in the history of server "+process.Name+"!", err)
+	}
+	return nil
+}
+
+// sendConsoleInput logs and records a command sent by a user, then sends it to the server. If the
+// server routes console input through RCON, the command's response is output to the console, and
+// the command is sent to stdin if RCON fails. If the command isn't allowed by the command policies,
+// errCommandNotAllowed is returned, and if it can't be written to stdin, the error is returned.
+func sendConsoleInput(
+	connector *Connector, r *http.Request, process *ExposedProcess, user string, limiter *rateLimiter, command string,
+) error {
+	err := recordConsoleInput(connector, r, process, user, limiter, command, "server.console.input")
+	if err != nil {
+		return err
+	}
+	process.ServerConfigMutex.RLock()
+	useRCON := process.RCON != nil && process.RCON.Console
+	process.ServerConfigMutex.RUnlock()
+	if useRCON {
+		response, err := process.ExecuteRCON(command)
+		if err == nil {
+			if response = strings.TrimRight(response, "\r\n"); response != "" {
+				process.SendConsoleOutput(response)
+			}
+			return nil
+		}
+		log.Println("Failed to send command to server "+process.Name+" over RCON, sending it to stdin!", err)
+	}
+	return process.SendCommand(command)
+}
+
+// POST /server/{id}/console
+func consoleEndpointPost(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	// Check with authenticator.
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, "server<"+id+">.console.write")
+	if user == "" || !hasPerm {
+		return
+	}
+	// Get the process being accessed.
+	process, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1024*1024))
+	if err != nil {
+		httpError(w, "Failed to read body!", http.StatusBadRequest)
+		return
+	}
+	command := strings.TrimSuffix(strings.TrimSuffix(string(body), "\n"), "\r")
+	if command == "" {
+		httpError(w, "No command provided!", http.StatusBadRequest)
+		return
+	} else if strings.ContainsAny(command, "\r\n") {
+		httpError(w, "Only a single command can be sent at once!", http.StatusBadRequest)
+		return
+	}
+	if err := checkConsoleInput(process, nil, command); err != nil {
+		httpError(w, consoleInputError(err, process.InputLimits()), consoleInputStatus(err))
+		return
+	} else if err := sendConsoleInput(connector, r, process, user, nil, command); err != nil {
+		httpError(w, consoleInputError(err, process.InputLimits()), consoleInputStatus(err))
+		return
+	}
+	writeJsonStringRes(w, "{\"success\":true}")
+}
+
+// POST /server/{id}/rcon
+func rconEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method != "POST" {
+		httpError(w, "Only POST is allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	// Check with authenticator.
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, "server<"+id+">.console.write")
+	if user == "" || !hasPerm {
+		return
+	}
+	// Get the process being accessed.
+	process, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	process.ServerConfigMutex.RLock()
+	enabled := process.RCON != nil
+	process.ServerConfigMutex.RUnlock()
+	if !enabled {
+		httpError(w, "RCON is not enabled for this server!", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1024*1024))
+	if err != nil {
+		httpError(w, "Failed to read body!", http.StatusBadRequest)
+		return
+	}
+	command := strings.TrimSuffix(strings.TrimSuffix(string(body), "\n"), "\r")
+	if command == "" {
+		httpError(w, "No command provided!", http.StatusBadRequest)
+		return
+	} else if strings.ContainsAny(command, "\r\n") {
+		httpError(w, "Only a single command can be sent at once!", http.StatusBadRequest)
+		return
+	}
+	if err := checkConsoleInput(process, nil, command); err != nil {
+		httpError(w, consoleInputError(err, process.InputLimits()), consoleInputStatus(err))
+		return
+	} else if err := recordConsoleInput(connector, r, process, user, nil, command, "server.console.rcon"); err != nil {
+		httpError(w, consoleInputError(err, process.InputLimits()), consoleInputStatus(err))
+		return
+	}
+	response, err := process.ExecuteRCON(command)
+	if errors.Is(err, rcon.ErrCommandTooLong) {
+		httpError(w, "This command is too long to be sent over RCON!", http.StatusBadRequest)
+		return
+	} else if errors.Is(err, rcon.ErrAuthFailed) {
+		httpError(w, "Failed to authenticate with the server over RCON!", http.StatusBadGateway)
+		return
+	} else if errors.Is(err, errRCONDisabled) {
+		httpError(w, "RCON is not enabled for this server!", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println("Failed to send command to server "+process.Name+" over RCON!", err)
+		httpError(w, "Failed to send command to the server over RCON!", http.StatusBadGateway)
+		return
+	}
+	writeJsonStructRes(w, struct {
+		Response string `json:"response"`
+	}{Response: response}) // skipcq GSC-G104
+}
+
+// GET /server/{id}/console/stream?ticket=ticket&since=seq
+func consoleStreamEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method != "GET" {
+		httpError(w, "Only GET is allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	// Check with authenticator.
+	user, hasPerm := connector.validateConsoleTicketWithPermAndReject(w, r, "server<"+id+">.console.view")
+	if user == "" || !hasPerm {
+		return
+	}
+	// Get the process being accessed.
+	process, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	// EventSource sets Last-Event-ID when reconnecting, while since can be used on first connect.
+	var since uint64
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("since")
+	}
+	if lastEventID != "" {
+		var err error
+		since, err = strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			httpError(w, "Invalid Last-Event-ID or since!", http.StatusBadRequest)
+			return
+		}
+	}
+	connector.Info("server.console.access", "ip", GetIP(r), "user", user, "server", id)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable buffering in nginx.
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	// Add the client to the process after queueing the output since the last event received.
+	process.ServerConfigMutex.RLock()
+	client := NewConsoleClient(process.Console.ClientQueueSize)
+	process.ServerConfigMutex.RUnlock()
+	(func() {
+		process.ConsoleLock.RLock()
+		defer process.ConsoleLock.RUnlock()
+		// If the clock went backwards across a restart of Octyne, send all the output.
+		if since > process.Scrollback.LastSeq() {
+			since = 0
+		}
+		client.Send(process.Scrollback.Since(since))
+		process.Clients.Store(client, auth.GetTokenFromRequest(r))
+	})()
+	defer process.Clients.Delete(client)
+	defer client.Close()
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		var data interface{}
+		select {
+		case data = <-client.Queue:
+		case <-keepAlive.C:
+			data = nil
+		case <-client.Closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if r.RemoteAddr != "@" {
+			if _, err := connector.Authenticator.GetUser(user); err != nil {
+				if !errors.Is(err, auth.ErrUserNotFound) {
+					log.Println("An error occurred while checking user in console stream endpoint!", err)
+				}
+				return
+			}
+		}
+		var err error
+		if dropped := client.TakeDropped(); dropped > 0 {
+			err = writeConsoleStreamEvent(w, "dropped", "", consoleDropped{"dropped", dropped})
+		}
+		switch data := data.(type) {
+		case nil:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		case ConsoleLine:
+			err = writeConsoleStreamEvent(w, "output", strconv.FormatUint(data.Seq, 10), data)
+		case []ConsoleLine:
+			for _, line := range data {
+				if err == nil {
+					err = writeConsoleStreamEvent(w, "output", strconv.FormatUint(line.Seq, 10), line)
+				}
+			}
+		case ConsoleEvent:
+			err = writeConsoleStreamEvent(w, "event", "", data)
+		}
+		if err == nil {
+			err = controller.Flush()
+		}
+		if err != nil {
+			return // The connection has terminated.
+		}
+	}
+}
+
+// writeConsoleStreamEvent writes a Server-Sent Event with JSON data to w.
+func writeConsoleStreamEvent(w io.Writer, event string, id string, data interface{}) error {
+	json, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	message := "event: " + event + "\n"
+	if id != "" {
+		message += "id: " + id + "\n"
+	}
+	_, err = io.WriteString(w, message+"data: "+string(json)+"\n\n")
+	return err
+}
+
+// GET /server/{id}/console/history?user=user&mine=false&from=time&to=time&limit=100
+func consoleHistoryEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method != "GET" {
+		httpError(w, "Only GET is allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	// Check with authenticator. Users who can write to the console can view their own commands.
+	mine := r.URL.Query().Get("mine") == "true"
+	perm := "server<" + id + ">.console.history"
+	if mine {
+		perm = "server<" + id + ">.console.write"
+	}
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, perm)
+	if user == "" || !hasPerm {
+		return
+	}
+	// Get the process being accessed.
+	process, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	filterUser := r.URL.Query().Get("user")
+	if mine {
+		filterUser = user
+	}
+	var from, to int64
+	limit := int64(100)
+	if !parseIntQuery(w, r, "from", &from, 0, 0) || !parseIntQuery(w, r, "to", &to, 0, 0) ||
+		!parseIntQuery(w, r, "limit", &limit, 1, 1000) {
+		return
+	}
+	history := process.History.Query(filterUser, from, to, int(limit))
+	writeJsonStructRes(w, map[string]interface{}{"history": history}) // skipcq GSC-G104
+}
+
+type multiConsoleServerSettings struct {
+	ReadOnly bool   `json:"readOnly"`
+	Status   int32  `json:"status"`
+	LastSeq  uint64 `json:"lastSeq"`
+}
+
+type multiConsoleSettings struct {
+	Type    string                                `json:"type"`
+	Servers map[string]multiConsoleServerSettings `json:"servers"`
+}
+
+type multiConsoleOutput struct {
+	Type   string        `json:"type"`
+	Server string        `json:"server"`
+	Lines  []ConsoleLine `json:"lines"`
+}
+
+type multiConsoleEvent struct {
+	Server string `json:"server"`
+	ConsoleEvent
+}
+
+type multiConsoleDropped struct {
+	Type   string `json:"type"`
+	Server string `json:"server"`
+	Lines  int64  `json:"lines"`
+}
+
+type multiConsoleError struct {
+	Type    string `json:"type"`
+	Server  string `json:"server,omitempty"`
+	Message string `json:"message"`
+}
+
+type multiConsoleInput struct {
+	Type    string   `json:"type"`
+	ID      string   `json:"id"`
+	Data    string   `json:"data"`
+	Servers []string `json:"servers"`
+}
+
+// multiConsoleServer is a server subscribed to by a multi-server console connection.
+type multiConsoleServer struct {
+	process      *ExposedProcess
+	client       *ConsoleClient
+	canWrite     bool
+	since        uint64
+	unsubscribed atomic.Bool // Set when the server is removed or the client is disconnected from it.
+}
+
+// multiConsoleMessage is a message queued for a multi-server console connection.
+type multiConsoleMessage struct {
+	server string
+	data   interface{}
+}
+
+// WS /servers/console?servers=servers&since=since&ticket=ticket
+func multiConsoleEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	// Check with authenticator.
+	ticket, ticketExists := connector.loadConsoleTicket(r)
+	user := ""
+	var userErr error = nil
+	if ticketExists {
+		user = ticket.User
+	} else {
+		user, userErr = connector.Authenticator.Validate(r)
+	}
+	// Retrieve the token.
+	token := auth.GetTokenFromRequest(r)
+	if ticketExists {
+		token = ticket.Token
+	}
+	// Get the servers being accessed. If no servers are specified, all servers the user can view
+	// are subscribed to.
+	servers := make(map[string]*multiConsoleServer)
+	errStr, errNo := "", 0
+	if user != "" && userErr == nil {
+		names := strings.Split(r.URL.Query().Get("servers"), ",")
+		if r.URL.Query().Get("servers") == "" {
+			names = nil
+			connector.Processes.Range(func(name string, _ *ExposedProcess) bool {
+				hasPerm, err := connector.Authenticator.HasPerm(user, "server<"+name+">.console.view")
+				if err != nil {
+					userErr = err
+					return false
+				} else if hasPerm {
+					names = append(names, name)
+				}
+				return true
+			})
+		}
+		for _, name := range names {
+			if userErr != nil {
+				break
+			}
+			process, exists := connector.Processes.Load(name)
+			if !exists {
+				errStr, errNo = "Server "+name+" does not exist!", 4000+http.StatusNotFound
+				break
+			}
+			var hasPerm, canWrite bool
+			hasPerm, userErr = connector.Authenticator.HasPerm(user, "server<"+name+">.console.view")
+			if userErr == nil {
+				canWrite, userErr = connector.Authenticator.HasPerm(user, "server<"+name+">.console.write")
+			}
+			if userErr == nil && !hasPerm {
+				errStr, errNo = "You are not allowed to access server "+name+"!", 4000+http.StatusForbidden
+				break
+			}
+			servers[name] = &multiConsoleServer{process: process, canWrite: canWrite}
+		}
+	}
+	// Parse the sequence number of the last line received from each server, e.g. server1:42,server2:7
+	if errStr == "" && r.URL.Query().Get("since") != "" {
+		for _, entry := range strings.Split(r.URL.Query().Get("since"), ",") {
+			name, seq, _ := strings.Cut(entry, ":")
+			since, err := strconv.ParseUint(seq, 10, 64)
+			if err != nil {
+				errStr, errNo = "Invalid since query parameter!", 4000+http.StatusBadRequest
+				break
+			} else if server, ok := servers[name]; ok {
+				server.since = since
+			}
+		}
+	}
+	if userErr != nil {
+		log.Println("An error occurred while validating authorization for an HTTP request!", userErr)
+		errStr, errNo = "Internal Server Error!", 4000+http.StatusInternalServerError
+	} else if user == "" {
+		errStr, errNo = "You are not authenticated to access this resource!", 4000+http.StatusUnauthorized
+	}
+	// Upgrade WebSocket connection.
+	c, err := connector.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	} else if errStr != "" {
+		c.WriteJSON(consoleError{"error", errStr})
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(errNo, errStr))
+		c.Close()
+		return
+	}
+	defer c.Close()
+	// Setup WebSocket limits, send settings and set read deadline.
+	timeout := 30 * time.Second
+	c.SetReadLimit(1024 * 1024) // Limit WebSocket reads to 1 MB.
+	c.SetReadDeadline(time.Now().Add(timeout))
+	settings := multiConsoleSettings{"settings", make(map[string]multiConsoleServerSettings)}
+	for name, server := range servers {
+		connector.Info("server.console.access", "ip", GetIP(r), "user", user, "server", name)
+		settings.Servers[name] = multiConsoleServerSettings{
+			ReadOnly: !server.canWrite,
+			Status:   server.process.Online.Load(),
+			LastSeq:  server.process.Scrollback.LastSeq(),
+		}
+	}
+	c.WriteJSON(settings)
+	// Messages from each server are queued separately, then forwarded to a single queue, which is
+	// used to synchronise all writes to the WebSocket.
+	queue := make(chan multiConsoleMessage, defaultClientQueueSize)
+	closed := make(chan struct{})
+	var closeOnce sync.Once
+	closeConnection := func() { closeOnce.Do(func() { close(closed) }) }
+	defer closeConnection()
+	send := func(message multiConsoleMessage) {
+		select {
+		case queue <- message:
+		case <-closed:
+		}
+	}
+	// If a server is removed or the client is too slow for it, the client is unsubscribed from it,
+	// and the connection is only closed once it isn't subscribed to any server.
+	var subscribed atomic.Int32
+	subscribed.Store(int32(len(servers)))
+	for name, server := range servers {
+		server.process.ServerConfigMutex.RLock()
+		server.client = NewConsoleClient(server.process.Console.ClientQueueSize)
+		server.process.ServerConfigMutex.RUnlock()
+		go (func() {
+			for {
+				select {
+				case data := <-server.client.Queue:
+					send(multiConsoleMessage{name, data})
+				case <-server.client.Closed:
+					server.unsubscribed.Store(true)
+					server.process.Clients.Delete(server.client)
+					reason := "Server " + name + " was removed!"
+					if process, ok := connector.Processes.Load(name); ok && process == server.process {
+						reason = "Disconnected from server " + name + " since the client is too slow!"
+					}
+					json, _ := json.Marshal(multiConsoleError{"unsubscribed", name, reason})
+					send(multiConsoleMessage{"", json})
+					if subscribed.Add(-1) <= 0 {
+						closeConnection()
+					}
+					return
+				case <-closed:
+					return
+				}
+			}
+		})()
+	}
+	go (func() {
+		for {
+			var message multiConsoleMessage
+			select {
+			case message = <-queue:
+			case <-closed:
+				c.Close()
+				return
+			}
+			if r.RemoteAddr != "@" {
+				if _, err := connector.Authenticator.GetUser(user); err != nil {
+					if !errors.Is(err, auth.ErrUserNotFound) {
+						log.Println("An error occurred while checking user in console endpoint!", err)
+					}
+					closeConnection()
+					continue
+				}
+			}
+			c.SetWriteDeadline(time.Now().Add(timeout))
+			if server, ok := servers[message.server]; ok {
+				if dropped := server.client.TakeDropped(); dropped > 0 {
+					c.WriteJSON(multiConsoleDropped{"dropped", message.server, dropped}) // skipcq GSC-G104
+				}
+			}
+			var data interface{}
+			switch value := message.data.(type) {
+			case []byte:
+				c.WriteMessage(websocket.TextMessage, value) // skipcq GSC-G104
+				continue
+			case ConsoleLine:
+				data = multiConsoleOutput{"output", message.server, []ConsoleLine{value}}
+			case []ConsoleLine:
+				data = multiConsoleOutput{"output", message.server, value}
+			case ConsoleEvent:
+				data = multiConsoleEvent{message.server, value}
+			default:
+				continue
+			}
+			json, err := json.Marshal(data)
+			if err != nil {
+				log.Println("Error in "+message.server+" console!", err)
+			} else {
+				c.WriteMessage(websocket.TextMessage, json) // skipcq GSC-G104
+			}
+		}
+	})()
+	// Add connection to each process after sending the output since the last line received.
+	for _, server := range servers {
+		(func() {
+			server.process.ConsoleLock.RLock()
+			defer server.process.ConsoleLock.RUnlock()
+			since := server.since
+			if since > server.process.Scrollback.LastSeq() {
+				since = 0 // The clock went backwards across a restart of Octyne.
+			}
+			server.client.Send(server.process.Scrollback.Since(since))
+			server.process.Clients.Store(server.client, token)
+		})()
+		defer server.client.Close()
+		defer server.process.Clients.Delete(server.client)
+	}
+	sendError := func(server string, message string) {
+		json, _ := json.Marshal(multiConsoleError{"error", server, message})
+		send(multiConsoleMessage{"", json})
+	}
+	var limiter rateLimiter
+	// Read messages from the user and execute them.
+	for {
+		_, message, err := c.ReadMessage()
+		if err != nil {
+			break // The WebSocket connection has terminated.
+		} else if r.RemoteAddr != "@" {
+			if _, err := connector.Authenticator.GetUser(user); err != nil {
+				if !errors.Is(err, auth.ErrUserNotFound) {
+					log.Println("An error occurred while checking user in console endpoint!", err)
+				}
+				break
+			}
+		}
+		c.SetReadDeadline(time.Now().Add(timeout)) // Update read deadline.
+		var data multiConsoleInput
+		if err := json.Unmarshal(message, &data); err != nil {
+			sendError("", "Invalid message format")
+		} else if data.Type == "ping" {
+			json, _ := json.Marshal(consolePing{"pong", data.ID})
+			send(multiConsoleMessage{"", json})
+		} else if data.Type == "input" && data.Data != "" {
+			command := strings.TrimSuffix(strings.TrimSuffix(data.Data, "\n"), "\r")
+			// The connection is charged once per input however many servers it's sent to, using the
+			// lowest rate limit of the servers, while each server is charged separately.
+			rate := 0.0
+			for _, name := range data.Servers {
+				if server, ok := servers[name]; ok && server.canWrite {
+					if limit := server.process.InputLimits().RateLimit; rate == 0 || limit < rate {
+						rate = limit
+					}
+				}
+			}
+			if rate > 0 && limiter.Delay(rate) > 0 {
+				sendError("", consoleInputError(errInputRateLimited, ConsoleInputConfig{}))
+				continue
+			}
+			allowed := false
+			for _, name := range data.Servers {
+				server, ok := servers[name]
+				if !ok || server.unsubscribed.Load() {
+					sendError(name, "Not subscribed to server "+name+"!")
+				} else if server.canWrite { // Simply drop inputs if the user cannot write.
+					err := checkConsoleInput(server.process, nil, command)
+					if err == nil {
+						err = sendConsoleInput(connector, r, server.process, user, nil, command)
+						allowed = allowed || !errors.Is(err, errCommandNotAllowed)
+					}
+					if err != nil {
+						sendError(name, consoleInputError(err, server.process.InputLimits()))
+					}
+				}
+			}
+			if allowed {
+				limiter.Take(rate)
+			}
+		} else {
+			sendError("", "Invalid message type: "+data.Type)
+		}
+	}
+}
@@ -9,16 +9,18 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/puzpuzpuz/xsync/v3"
 	"github.com/retrixe/octyne/system"
@@ -396,7 +398,7 @@ func fileEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request)
 	id := r.PathValue("id")
 	var perm string
 	switch r.Method {
-	case "GET":
+	case "GET", "HEAD":
 		perm = "server<" + id + ">.files.download"
 	case "DELETE":
 		perm = "server<" + id + ">.files.modify"
@@ -405,15 +407,17 @@ func fileEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request)
 	case "PATCH":
 		perm = "server<" + id + ">.files.modify"
 	default:
-		httpError(w, "Only GET, POST, PATCH and DELETE are allowed!", http.StatusMethodNotAllowed)
+		httpError(w, "Only GET, HEAD, POST, PATCH and DELETE are allowed!", http.StatusMethodNotAllowed)
 		return
 	}
-	ticket, ticketExists := connector.Tickets.LoadAndDelete(r.URL.Query().Get("ticket"))
-	user := ""
-	hasPerm := false
-	if ticketExists && ticket.IPAddr == GetIP(r) && r.Method == "GET" {
-		user = ticket.User
-	} else if user, hasPerm = connector.ValidateWithPermAndReject(w, r, perm); user == "" || !hasPerm {
+	var user string
+	var hasPerm bool
+	if r.Method == "GET" || r.Method == "HEAD" {
+		user, hasPerm = connector.validateTicketWithPermAndReject(w, r, perm)
+	} else {
+		user, hasPerm = connector.ValidateWithPermAndReject(w, r, perm)
+	}
+	if user == "" || !hasPerm {
 		return
 	}
 	// Get the process being accessed.
@@ -427,12 +431,12 @@ func fileEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request)
 	process.ServerConfigMutex.RLock()
 	defer process.ServerConfigMutex.RUnlock()
 	filePath, err := resolvePath(process.Directory, r.URL.Query().Get("path"))
-	if (r.Method == "GET" || r.Method == "POST" || r.Method == "DELETE") && err != nil {
+	if r.Method != "PATCH" && err != nil {
 		httpError(w, "Invalid file path: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 	switch r.Method {
-	case "GET":
+	case "GET", "HEAD":
 		fileEndpointGet(connector, w, r, id, filePath, user)
 	case "DELETE":
 		fileEndpointDelete(connector, w, r, id, filePath, user)
@@ -445,10 +449,14 @@ func fileEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request)
 
 func fileEndpointGet(connector *Connector, w http.ResponseWriter, r *http.Request,
 	id string, filePath string, user string) {
-	// Get list of files and folders in the directory.
 	file, err := os.Open(filePath)
-	stat, err1 := file.Stat()
-	if err != nil || err1 != nil {
+	if err != nil {
+		httpError(w, "This file does not exist!", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
 		httpError(w, "This file does not exist!", http.StatusNotFound)
 		return
 	} else if !stat.Mode().IsRegular() {
@@ -457,16 +465,18 @@ func fileEndpointGet(connector *Connector, w http.ResponseWriter, r *http.Reques
 	}
 	// Send the response.
 	buffer := make([]byte, 512)
-	file.Read(buffer) // skipcq GSC-G104
-	file.Close()      // skipcq GSC-G104
-	w.Header().Set("Content-Disposition", "attachment; filename="+stat.Name())
-	w.Header().Set("Content-Type", http.DetectContentType(buffer))
-	w.Header().Set("Content-Length", fmt.Sprint(stat.Size()))
-	file, _ = os.Open(filePath)
-	defer file.Close()
-	connector.Info("server.files.download", "ip", GetIP(r), "user", user, "server", id,
-		"path", path.Clean(r.URL.Query().Get("path")))
-	io.Copy(w, file)
+	n, _ := file.Read(buffer)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		log.Println("An error occurred when reading "+filePath, "("+id+")", err)
+		httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(buffer[:n]))
+	if r.Method == "GET" {
+		connector.Info("server.files.download", "ip", GetIP(r), "user", user, "server", id,
+			"path", path.Clean(r.URL.Query().Get("path")), "range", r.Header.Get("Range"))
+	}
+	serveDownload(w, r, file, stat, stat.Name())
 }
 
 func fileEndpointPost(connector *Connector, w http.ResponseWriter, r *http.Request,
@@ -590,6 +600,268 @@ func fileEndpointDelete(connector *Connector, w http.ResponseWriter, r *http.Req
 	writeJsonStringRes(w, "{\"success\":true}")
 }
 
+// GET /server/{id}/file/content?path=path
+// PUT /server/{id}/file/content?path=path
+func fileContentEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var perm string
+	switch r.Method {
+	case "GET":
+		perm = "server<" + id + ">.files.download"
+	case "PUT":
+		perm = "server<" + id + ">.files.modify"
+	default:
+		httpError(w, "Only GET and PUT are allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, perm)
+	if user == "" || !hasPerm {
+		return
+	}
+	// Get the process being accessed.
+	process, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	// Check if path is in the process directory or not.
+	process.ServerConfigMutex.RLock()
+	filePath, err := resolvePath(process.Directory, r.URL.Query().Get("path"))
+	process.ServerConfigMutex.RUnlock()
+	if err != nil {
+		httpError(w, "Invalid file path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Read the new contents before reading the file.
+	var contents []byte
+	if r.Method == "PUT" {
+		contents, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxFileContentSize))
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			httpError(w, "The file is too large! The maximum size is 10 MB.", http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			httpError(w, "Failed to read body!", http.StatusBadRequest)
+			return
+		} else if !utf8.Valid(contents) {
+			httpError(w, "The file contents must be valid UTF-8 text!", http.StatusBadRequest)
+			return
+		}
+	}
+	fileEditMutex.Lock()
+	defer fileEditMutex.Unlock()
+	// Read the current file and compute its ETag.
+	etag := ""
+	var current []byte
+	stat, err := os.Stat(filePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Println("An error occurred when reading "+filePath, "("+id+")", err)
+		httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+		return
+	} else if err == nil && !stat.Mode().IsRegular() {
+		httpError(w, "This is not a file!", http.StatusBadRequest)
+		return
+	} else if err == nil && stat.Size() > maxFileContentSize {
+		httpError(w, "This file is too large to be edited! The maximum size is 10 MB.", http.StatusBadRequest)
+		return
+	} else if err == nil {
+		current, err = os.ReadFile(filePath)
+		if err != nil {
+			log.Println("An error occurred when reading "+filePath, "("+id+")", err)
+			httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+			return
+		}
+		etag = fileETag(current, stat.ModTime())
+	}
+	if r.Method == "GET" {
+		if etag == "" {
+			httpError(w, "This file does not exist!", http.StatusNotFound)
+			return
+		} else if !utf8.Valid(current) || bytes.IndexByte(current, 0) != -1 {
+			httpError(w, "This file is not a text file!", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		connector.Info("server.files.download", "ip", GetIP(r), "user", user, "server", id,
+			"path", path.Clean(r.URL.Query().Get("path")))
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(current) // skipcq GSC-G104
+		return
+	}
+	// Only write the file if it hasn't been changed since the client last read it.
+	ifMatch := r.Header.Get("If-Match")
+	if etag != "" && ifMatch == "" {
+		httpError(w, "The If-Match header is required to overwrite an existing file!", http.StatusPreconditionRequired)
+		return
+	} else if (etag == "" && ifMatch != "") || (etag != "" && !etagMatches(ifMatch, etag)) {
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		httpError(w, "The file has been modified since it was last read!", http.StatusPreconditionFailed)
+		return
+	}
+	if stat, err := os.Stat(filepath.Dir(filePath)); err != nil || !stat.IsDir() {
+		httpError(w, "The folder of this file does not exist!", http.StatusNotFound)
+		return
+	}
+	err = writeFileAtomic(filePath, contents)
+	if err == nil {
+		stat, err = os.Stat(filePath)
+	}
+	if err != nil {
+		log.Println("An error occurred when writing to "+filePath, "("+id+")", err)
+		httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+		return
+	}
+	connector.Info("server.files.edit", "ip", GetIP(r), "user", user, "server", id,
+		"path", path.Clean(r.URL.Query().Get("path")), "created", etag == "")
+	w.Header().Set("ETag", fileETag(contents, stat.ModTime()))
+	writeJsonStringRes(w, "{\"success\":true}")
+}
+
+type uploadCreateBody struct {
+	Size int64 `json:"size"`
+}
+
+type uploadCompleteBody struct {
+	SHA256 string `json:"sha256"`
+}
+
+// POST /server/{id}/uploads?path=path
+func uploadsEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method != "POST" {
+		httpError(w, "Only POST is allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, "server<"+id+">.files.upload")
+	if user == "" || !hasPerm {
+		return
+	}
+	// Get the process being accessed.
+	process, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	var body uploadCreateBody
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024*1024)).Decode(&body); err != nil {
+		httpError(w, "Invalid JSON body!", http.StatusBadRequest)
+		return
+	} else if body.Size < 0 {
+		httpError(w, "Invalid size!", http.StatusBadRequest)
+		return
+	}
+	// Check if path is in the process directory or not.
+	process.ServerConfigMutex.RLock()
+	filePath, err := resolvePath(process.Directory, r.URL.Query().Get("path"))
+	directory := filepath.Clean(process.Directory)
+	process.ServerConfigMutex.RUnlock()
+	if err != nil || filePath == directory {
+		httpError(w, "Invalid file path!", http.StatusBadRequest)
+		return
+	} else if stat, err := os.Stat(filepath.Dir(filePath)); err != nil || !stat.IsDir() {
+		httpError(w, "The folder of this file does not exist!", http.StatusNotFound)
+		return
+	} else if stat, err := os.Stat(filePath); err == nil && stat.IsDir() {
+		httpError(w, "This is a folder!", http.StatusBadRequest)
+		return
+	}
+	relPath := path.Clean("/" + r.URL.Query().Get("path"))[1:]
+	session, err := NewUploadSession(id, user, relPath, filePath, body.Size)
+	if err != nil {
+		log.Println("An error occurred when creating an upload for "+filePath, "("+id+")", err)
+		httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+		return
+	}
+	writeJsonStructRes(w, session.Status()) // skipcq GSC-G104
+}
+
+// GET /server/{id}/uploads/{upload}
+// PATCH /server/{id}/uploads/{upload}?offset=offset
+// POST /server/{id}/uploads/{upload}
+// DELETE /server/{id}/uploads/{upload}
+func uploadEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method != "GET" && r.Method != "PATCH" && r.Method != "POST" && r.Method != "DELETE" {
+		httpError(w, "Only GET, PATCH, POST and DELETE are allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, "server<"+id+">.files.upload")
+	if user == "" || !hasPerm {
+		return
+	}
+	// Uploads can only be accessed by the user who created them.
+	session, ok := uploadSessions.Load(r.PathValue("upload"))
+	if !ok || session.Server != id || session.User != user {
+		httpError(w, "This upload does not exist!", http.StatusNotFound)
+		return
+	} else if _, ok := connector.Processes.Load(id); !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		writeJsonStructRes(w, session.Status()) // skipcq GSC-G104
+	case "PATCH":
+		offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		if err != nil {
+			httpError(w, "Invalid offset!", http.StatusBadRequest)
+			return
+		}
+		err = session.Write(offset, r.Body)
+		if errors.Is(err, errUploadClosed) {
+			httpError(w, "This upload does not exist!", http.StatusNotFound)
+		} else if errors.Is(err, errUploadOffsetMismatch) {
+			httpError(w, "The offset does not match the offset of the upload! The upload is at offset "+
+				strconv.FormatInt(session.Status().Offset, 10)+".", http.StatusConflict)
+		} else if errors.Is(err, errUploadTooLarge) {
+			httpError(w, "The chunk exceeds the size of the upload!", http.StatusRequestEntityTooLarge)
+		} else if err != nil {
+			log.Println("An error occurred when writing to upload "+session.ID, "("+id+")", err)
+			httpError(w, "Failed to write chunk! The upload is at offset "+
+				strconv.FormatInt(session.Status().Offset, 10)+".", http.StatusInternalServerError)
+		} else {
+			writeJsonStructRes(w, session.Status()) // skipcq GSC-G104
+		}
+	case "POST":
+		var body uploadCompleteBody
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024*1024)).Decode(&body); err != nil &&
+			!errors.Is(err, io.EOF) {
+			httpError(w, "Invalid JSON body!", http.StatusBadRequest)
+			return
+		}
+		err := session.Complete(body.SHA256)
+		if errors.Is(err, errUploadClosed) {
+			httpError(w, "This upload does not exist!", http.StatusNotFound)
+		} else if errors.Is(err, errUploadIncomplete) {
+			httpError(w, "The upload is incomplete!", http.StatusConflict)
+		} else if errors.Is(err, errUploadChecksum) {
+			httpError(w, "The checksum does not match the uploaded file! The upload has been cancelled.",
+				http.StatusBadRequest)
+		} else if err != nil && system.IsFileLocked(err) {
+			// Completing an upload can fail with an *os.PathError or an *os.LinkError.
+			httpError(w, errors.Unwrap(err).Error(), http.StatusConflict)
+		} else if err != nil {
+			log.Println("An error occurred when completing upload to "+session.FilePath, "("+id+")", err)
+			httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+		} else {
+			connector.Info("server.files.upload", "ip", GetIP(r), "user", user, "server", id,
+				"path", session.Path, "size", session.Size)
+			writeJsonStringRes(w, "{\"success\":true}")
+		}
+	case "DELETE":
+		session.Cancel()
+		writeJsonStringRes(w, "{\"success\":true}")
+	}
+}
+
 // POST /server/{id}/folder?path=path
 func folderEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
@@ -907,3 +1179,201 @@ func decompressionEndpoint(connector *Connector, w http.ResponseWriter, r *http.
 		httpError(w, "Only POST is allowed!", http.StatusMethodNotAllowed)
 	}
 }
+
+// fileEditMutex is held while files are being edited with the properties and file content
+// endpoints, so concurrent edits can't overwrite each other.
+var fileEditMutex sync.Mutex
+
+// GET /server/{id}/properties?path=path
+// PATCH /server/{id}/properties?path=path&restart=false
+func propertiesEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var perm string
+	switch r.Method {
+	case "GET":
+		perm = "server<" + id + ">.files.download"
+	case "PATCH":
+		perm = "server<" + id + ">.files.modify"
+	default:
+		httpError(w, "Only GET and PATCH are allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, perm)
+	if user == "" || !hasPerm {
+		return
+	}
+	restart := r.URL.Query().Get("restart") == "true"
+	if restart {
+		if hasPerm, err := connector.Authenticator.HasPerm(user, "server<"+id+">.control"); err != nil {
+			log.Println("An error occurred while checking permissions for user \""+user+"\"!", err)
+			httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+			return
+		} else if !hasPerm {
+			httpError(w, "You are not allowed to access this resource!", http.StatusForbidden)
+			return
+		}
+	}
+	// Get the process being accessed.
+	process, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	// Check if path is in the process directory or not.
+	propertiesPath := r.URL.Query().Get("path")
+	if propertiesPath == "" {
+		propertiesPath = "server.properties"
+	}
+	if !strings.HasSuffix(propertiesPath, ".properties") {
+		httpError(w, "Only .properties files are supported!", http.StatusBadRequest)
+		return
+	}
+	process.ServerConfigMutex.RLock()
+	filePath, err := resolvePath(process.Directory, propertiesPath)
+	process.ServerConfigMutex.RUnlock()
+	if err != nil {
+		httpError(w, "Invalid file path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Parse the request body before reading the file.
+	var updates map[string]*string
+	if r.Method == "PATCH" {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024*1024)).Decode(&updates); err != nil {
+			httpError(w, "Invalid JSON body!", http.StatusBadRequest)
+			return
+		}
+	}
+	fileEditMutex.Lock()
+	defer fileEditMutex.Unlock()
+	contents, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		httpError(w, "This file does not exist!", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("An error occurred when reading "+filePath, "("+id+")", err)
+		httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+		return
+	}
+	properties := ParseProperties(string(contents))
+	if r.Method == "GET" {
+		writeJsonStructRes(w, map[string]interface{}{"properties": properties.Map()}) // skipcq GSC-G104
+		return
+	}
+	keys := make([]string, 0, len(updates))
+	for key, value := range updates {
+		if value == nil {
+			properties.Delete(key)
+		} else {
+			properties.Set(key, *value)
+		}
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	err = writeFileAtomic(filePath, []byte(properties.String()))
+	if err != nil {
+		log.Println("An error occurred when writing to "+filePath, "("+id+")", err)
+		httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+		return
+	}
+	connector.Info("server.files.editProperties", "ip", GetIP(r), "user", user, "server", id,
+		"path", path.Clean(propertiesPath), "keys", keys, "restart", restart)
+	if restart && process.Online.Load() == 1 {
+		go process.RestartProcess(connector)
+	}
+	writeJsonStructRes(w, map[string]interface{}{"properties": properties.Map()}) // skipcq GSC-G104
+}
+
+type pluginsPatchBody struct {
+	Folder  string `json:"folder"`
+	File    string `json:"file"`
+	Enabled bool   `json:"enabled"`
+}
+
+// GET /server/{id}/plugins
+// PATCH /server/{id}/plugins
+func pluginsEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var perm string
+	switch r.Method {
+	case "GET":
+		perm = "server<" + id + ">.files.view"
+	case "PATCH":
+		perm = "server<" + id + ">.files.modify"
+	default:
+		httpError(w, "Only GET and PATCH are allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, perm)
+	if user == "" || !hasPerm {
+		return
+	}
+	// Get the process being accessed.
+	process, ok := connector.Processes.Load(id)
+	// In case the process doesn't exist.
+	if !ok {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+		return
+	}
+	process.ServerConfigMutex.RLock()
+	directory := process.Directory
+	process.ServerConfigMutex.RUnlock()
+	if r.Method == "PATCH" {
+		var body pluginsPatchBody
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024*1024)).Decode(&body); err != nil {
+			httpError(w, "Invalid JSON body!", http.StatusBadRequest)
+			return
+		} else if !slices.Contains(pluginFolders, body.Folder) {
+			httpError(w, "Invalid folder! Must be one of: "+strings.Join(pluginFolders, ", "), http.StatusBadRequest)
+			return
+		} else if body.File == "" || body.File != filepath.Base(body.File) || strings.ContainsAny(body.File, "/\\") ||
+			!strings.HasSuffix(strings.ToLower(body.File), ".jar") {
+			httpError(w, "Invalid file! Must be the name of a .jar file.", http.StatusBadRequest)
+			return
+		}
+		enabledPath := filepath.Join(directory, body.Folder, body.File)
+		disabledPath := filepath.Join(directory, body.Folder, disabledPluginFolder, body.File)
+		src, dest := disabledPath, enabledPath
+		if !body.Enabled {
+			src, dest = enabledPath, disabledPath
+		}
+		if _, err := os.Stat(src); errors.Is(err, os.ErrNotExist) {
+			if _, err := os.Stat(dest); err == nil && body.Enabled {
+				httpError(w, "This plugin is already enabled!", http.StatusConflict)
+			} else if err == nil {
+				httpError(w, "This plugin is already disabled!", http.StatusConflict)
+			} else {
+				httpError(w, "This plugin does not exist!", http.StatusNotFound)
+			}
+			return
+		} else if _, err := os.Stat(dest); err == nil {
+			httpError(w, "A plugin with this name already exists in the destination folder!", http.StatusConflict)
+			return
+		}
+		err := os.MkdirAll(filepath.Dir(disabledPath), os.ModePerm)
+		if err == nil {
+			err = os.Rename(src, dest)
+		}
+		if err != nil && system.IsFileLocked(err) {
+			httpError(w, errors.Unwrap(err).Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Println("An error occurred when moving "+src+" to "+dest, "("+id+")", err)
+			httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+			return
+		}
+		action := "server.plugins.disable"
+		if body.Enabled {
+			action = "server.plugins.enable"
+		}
+		connector.Info(action, "ip", GetIP(r), "user", user, "server", id,
+			"folder", body.Folder, "file", body.File)
+	}
+	plugins, err := listPlugins(directory)
+	if err != nil {
+		log.Println("An error occurred when listing plugins of server "+id, err)
+		httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+		return
+	}
+	writeJsonStructRes(w, map[string]interface{}{"plugins": plugins}) // skipcq GSC-G104
+}
@@ -8,7 +8,9 @@ import (
 	"net/http"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -63,6 +65,8 @@ func configEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request
 			httpError(w, "Invalid JSON body!", http.StatusBadRequest)
 			return
 		}
+		ConfigJsonMutex.Lock()
+		defer ConfigJsonMutex.Unlock()
 		err = os.WriteFile(ConfigJsonPath+"~", []byte(strings.TrimSpace(origJson)+"\n"), 0666)
 		if err != nil {
 			log.Println("Error writing to " + ConfigJsonPath + " when user modified config!")
@@ -120,6 +124,9 @@ func serversEndpoint(connector *Connector, w http.ResponseWriter, r *http.Reques
 	// Get a map of processes and their online status.
 	processes := make(map[string]interface{})
 	errored := false
+	extraInfo := r.URL.Query().Get("extrainfo") == "true"
+	// Get the status of Minecraft servers concurrently, since it may take a while.
+	var minecraftWait sync.WaitGroup
 	connector.Processes.Range(func(name string, v *ExposedProcess) bool {
 		hasPerm, err := connector.Authenticator.HasPerm(user, "server<"+name+">.view")
 		if !hasPerm {
@@ -129,16 +136,25 @@ func serversEndpoint(connector *Connector, w http.ResponseWriter, r *http.Reques
 			httpError(w, "Internal Server Error!", http.StatusInternalServerError)
 			errored = true
 			return false
-		} else if r.URL.Query().Get("extrainfo") == "true" {
-			processes[name] = map[string]interface{}{
+		} else if extraInfo {
+			extra := map[string]interface{}{
 				"status":   v.Online.Load(),
 				"toDelete": v.ToDelete.Load(),
 			}
+			processes[name] = extra
+			minecraftWait.Add(1)
+			go (func() {
+				defer minecraftWait.Done()
+				if status := v.MinecraftStatus(); status != nil {
+					extra["minecraft"] = status
+				}
+			})()
 		} else {
 			processes[name] = v.Online.Load()
 		}
 		return true
 	})
+	minecraftWait.Wait()
 	// Send the list.
 	if errored {
 		return
@@ -148,6 +164,8 @@ func serversEndpoint(connector *Connector, w http.ResponseWriter, r *http.Reques
 
 // GET /server/{id}
 // POST /server/{id}
+// PUT /server/{id}
+// DELETE /server/{id}
 type serverResponse struct {
 	Status      int     `json:"status"`
 	CPUUsage    float64 `json:"cpuUsage"`
@@ -155,6 +173,12 @@ type serverResponse struct {
 	TotalMemory int64   `json:"totalMemory"`
 	Uptime      int64   `json:"uptime"`
 	ToDelete    bool    `json:"toDelete,omitempty"`
+	// Console clients and the number of lines of output dropped for slow clients.
+	ConsoleClients int   `json:"consoleClients"`
+	DroppedLines   int64 `json:"droppedConsoleLines"`
+	QueuedInput    int   `json:"queuedInput"` // Lines of input waiting to be written to stdin.
+	// Status of Minecraft servers, if enabled and the server is online.
+	Minecraft *MinecraftStatus `json:"minecraft,omitempty"`
 }
 
 var totalMemory = int64(system.GetTotalSystemMemory())
@@ -168,13 +192,21 @@ func serverEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request
 		perm = "server<" + id + ">.view"
 	case "POST":
 		perm = "server<" + id + ">.control"
+	case "PUT", "DELETE":
+		perm = "config.edit"
 	default:
-		httpError(w, "Only GET and POST is allowed!", http.StatusMethodNotAllowed)
+		httpError(w, "Only GET, POST, PUT and DELETE are allowed!", http.StatusMethodNotAllowed)
 		return
 	}
 	user, hasPerm := connector.ValidateWithPermAndReject(w, r, perm)
 	if user == "" || !hasPerm {
 		return
+	} else if r.Method == "PUT" {
+		serverEndpointPut(connector, w, r, id, user)
+		return
+	} else if r.Method == "DELETE" {
+		serverEndpointDelete(connector, w, r, id, user)
+		return
 	}
 	// Get the process being accessed.
 	process, err := connector.Processes.Load(id)
@@ -192,6 +224,8 @@ func serverEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request
 }
 
 func serverEndpointGet(w http.ResponseWriter, process *ExposedProcess) {
+	// Get the status of Minecraft servers first, since it may take a while.
+	minecraftStatus := process.MinecraftStatus()
 	// Get the PID of the process.
 	var stat system.ProcessStats
 	process.CommandMutex.RLock()
@@ -222,6 +256,11 @@ func serverEndpointGet(w http.ResponseWriter, process *ExposedProcess) {
 		MemoryUsage: stat.RSSMemory,
 		TotalMemory: totalMemory,
 		ToDelete:    process.ToDelete.Load(),
+
+		ConsoleClients: process.Clients.Size(),
+		DroppedLines:   process.DroppedLines.Load(),
+		QueuedInput:    len(process.InputQueue) + len(process.ControlQueue),
+		Minecraft:      minecraftStatus,
 	}
 	writeJsonStructRes(w, res) // skipcq GSC-G104
 }
@@ -290,15 +329,47 @@ type consoleSettings struct {
 	ReadOnly bool   `json:"readOnly"`
 }
 
+type consoleSettingsV3 struct {
+	consoleSettings
+	Status  int32  `json:"status"`
+	LastSeq uint64 `json:"lastSeq"`
+}
+
+type consoleOutput struct {
+	Type  string        `json:"type"`
+	Lines []ConsoleLine `json:"lines"`
+}
+
+type consolePasteConfirmation struct {
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Lines int    `json:"lines"`
+}
+
+type consoleDropped struct {
+	Type  string `json:"type"`
+	Lines int64  `json:"lines"`
+}
+
 func consoleEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		consoleEndpointPost(connector, w, r)
+		return
+	}
 	id := r.PathValue("id")
-	// Get console protocol version.
-	v2 := slices.Contains(websocket.Subprotocols(r), "console-v2")
+	// Get console protocol version. console-v3 is a superset of console-v2.
+	v3 := slices.Contains(websocket.Subprotocols(r), "console-v3")
+	v2 := v3 || slices.Contains(websocket.Subprotocols(r), "console-v2")
+	var since uint64
+	var sinceErr error
+	if v3 && r.URL.Query().Get("since") != "" {
+		since, sinceErr = strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+	}
 	// Check with authenticator.
-	ticket, ticketExists := connector.Tickets.LoadAndDelete(r.URL.Query().Get("ticket"))
+	ticket, ticketExists := connector.loadConsoleTicket(r)
 	user := ""
 	var userErr error = nil
-	if ticketExists && ticket.IPAddr == GetIP(r) {
+	if ticketExists {
 		user = ticket.User
 	} else {
 		user, userErr = connector.Authenticator.Validate(r)
@@ -351,6 +422,9 @@ func consoleEndpoint(connector *Connector, w http.ResponseWriter, r *http.Reques
 			} else if !hasPerm {
 				errStr = "You are not allowed to access this resource!"
 				errNo = 4000 + http.StatusForbidden
+			} else if sinceErr != nil {
+				errStr = "Invalid since query parameter!"
+				errNo = 4000 + http.StatusBadRequest
 			}
 			if errStr != "" {
 				c.WriteJSON(consoleError{"error", errStr})
@@ -365,22 +439,32 @@ func consoleEndpoint(connector *Connector, w http.ResponseWriter, r *http.Reques
 		timeout := 30 * time.Second
 		c.SetReadLimit(1024 * 1024) // Limit WebSocket reads to 1 MB.
 		// If v2, send settings and set read deadline.
-		if v2 {
+		if v3 {
+			c.SetReadDeadline(time.Now().Add(timeout))
+			c.WriteJSON(consoleSettingsV3{
+				consoleSettings: consoleSettings{"settings", !canWrite},
+				Status:          process.Online.Load(),
+				LastSeq:         process.Scrollback.LastSeq(),
+			})
+		} else if v2 {
 			c.SetReadDeadline(time.Now().Add(timeout))
 			c.WriteJSON(consoleSettings{"settings", !canWrite})
 		}
-		// Use a channel to synchronise all writes to the WebSocket.
-		writeChannel := make(chan interface{}, 8)
-		defer close(writeChannel)
+		// Use a queue to synchronise all writes to the WebSocket.
+		process.ServerConfigMutex.RLock()
+		client := NewConsoleClient(process.Console.ClientQueueSize)
+		process.ServerConfigMutex.RUnlock()
+		defer client.Close()
 		go (func() {
 			for {
-				data, ok := <-writeChannel
-				if !ok {
-					break
-				} else if data == nil {
+				var data interface{}
+				select {
+				case data = <-client.Queue:
+				case <-client.Closed:
 					c.Close()
-					break
-				} else if r.RemoteAddr != "@" {
+					return
+				}
+				if r.RemoteAddr != "@" {
 					if _, err := connector.Authenticator.GetUser(user); err != nil {
 						if !errors.Is(err, auth.ErrUserNotFound) {
 							log.Println("An error occurred while checking user in console endpoint!", err)
@@ -390,18 +474,52 @@ func consoleEndpoint(connector *Connector, w http.ResponseWriter, r *http.Reques
 					}
 				}
 				c.SetWriteDeadline(time.Now().Add(timeout)) // Set write deadline esp for v1 connections.
-				str, ok := data.(string)
-				if ok && v2 {
-					json, err := json.Marshal(consoleData{"output", str})
-					if err != nil {
-						log.Println("Error in "+process.Name+" console!", err)
-					} else {
+				if dropped := client.TakeDropped(); dropped > 0 && v3 {
+					json, _ := json.Marshal(consoleDropped{"dropped", dropped})
+					c.WriteMessage(websocket.TextMessage, json) // skipcq GSC-G104
+				} else if dropped > 0 {
+					notice := "[Octyne] " + strconv.FormatInt(dropped, 10) +
+						" lines of console output were dropped, since this connection is too slow!"
+					if v2 {
+						json, _ := json.Marshal(consoleData{"output", notice})
 						c.WriteMessage(websocket.TextMessage, json) // skipcq GSC-G104
+					} else {
+						c.WriteMessage(websocket.TextMessage, []byte(notice)) // skipcq GSC-G104
+					}
+				}
+				var message interface{}
+				switch data := data.(type) {
+				case []byte:
+					c.WriteMessage(websocket.TextMessage, data) // skipcq GSC-G104
+					continue
+				case ConsoleLine:
+					if v3 {
+						message = consoleOutput{"output", []ConsoleLine{data}}
+					} else if v2 {
+						message = consoleData{"output", data.Text}
+					} else {
+						c.WriteMessage(websocket.TextMessage, []byte(data.Text)) // skipcq GSC-G104
+						continue
+					}
+				case []ConsoleLine:
+					message = consoleOutput{"output", data}
+				case string:
+					if !v2 {
+						c.WriteMessage(websocket.TextMessage, []byte(data)) // skipcq GSC-G104
+						continue
+					}
+					message = consoleData{"output", data}
+				case ConsoleEvent:
+					if !v3 {
+						continue // Events are only sent to console-v3 clients.
 					}
-				} else if ok {
-					c.WriteMessage(websocket.TextMessage, []byte(str)) // skipcq GSC-G104
+					message = data
+				}
+				json, err := json.Marshal(message)
+				if err != nil {
+					log.Println("Error in "+process.Name+" console!", err)
 				} else {
-					c.WriteMessage(websocket.TextMessage, data.([]byte)) // skipcq GSC-G104
+					c.WriteMessage(websocket.TextMessage, json) // skipcq GSC-G104
 				}
 			}
 		})()
@@ -409,26 +527,48 @@ func consoleEndpoint(connector *Connector, w http.ResponseWriter, r *http.Reques
 		(func() {
 			process.ConsoleLock.RLock()
 			defer process.ConsoleLock.RUnlock()
-			writeChannel <- process.Console
-			process.Clients.Store(writeChannel, token)
+			if v3 {
+				// If the clock went backwards across a restart of Octyne, since may be newer than
+				// the last line of output, in which case all the output should be sent.
+				if since > process.Scrollback.LastSeq() {
+					since = 0
+				}
+				client.Send(process.Scrollback.Since(since))
+			} else {
+				client.Send(process.Scrollback.String())
+			}
+			process.Clients.Store(client, token)
 		})()
+		input := &consoleInput{}
+		sendError := func(err error) {
+			message := consoleInputError(err, process.InputLimits())
+			if v2 {
+				json, _ := json.Marshal(consoleError{"error", message})
+				client.Send(json)
+			} else {
+				client.Send("[Octyne] " + message)
+			}
+		}
+		sendInput := func(command string) error {
+			return sendConsoleInput(connector, r, process, user, &input.limiter, command)
+		}
 		// Read messages from the user and execute them.
 		for {
-			_, ok := process.Clients.Load(writeChannel) // If gone, stop reading messages from client.
+			_, ok := process.Clients.Load(client) // If gone, stop reading messages from client.
 			if !ok {
 				break
 			}
 			// Read messages from the user.
 			_, message, err := c.ReadMessage()
 			if err != nil {
-				process.Clients.Delete(writeChannel)
+				process.Clients.Delete(client)
 				break // The WebSocket connection has terminated.
 			} else if r.RemoteAddr != "@" {
 				if _, err := connector.Authenticator.GetUser(user); err != nil {
 					if !errors.Is(err, auth.ErrUserNotFound) {
 						log.Println("An error occurred while checking user in console endpoint!", err)
 					}
-					process.Clients.Delete(writeChannel)
+					process.Clients.Delete(client)
 					c.Close()
 					break
 				}
@@ -440,24 +580,45 @@ func consoleEndpoint(connector *Connector, w http.ResponseWriter, r *http.Reques
 				if err == nil {
 					if data["type"] == "input" && data["data"] != "" && canWrite {
 						// Simply drop inputs if the user cannot write.
-						connector.Info("server.console.input", "ip", GetIP(r), "user", user, "server", id,
-							"input", data["data"])
-						process.SendCommand(data["data"])
+						// Multi-line input is held until the client confirms it.
+						if lines := splitConsoleInput(data["data"]); len(lines) > 1 {
+							id, err := input.Paste(process, lines)
+							if err != nil {
+								sendError(err)
+							} else {
+								json, _ := json.Marshal(consolePasteConfirmation{
+									"pasteConfirmation", strconv.FormatUint(id, 10), len(lines)})
+								client.Send(json)
+							}
+						} else if err := checkConsoleInput(process, &input.limiter, lines[0]); err != nil {
+							sendError(err)
+						} else if err := sendInput(lines[0]); err != nil {
+							sendError(err)
+						}
+					} else if data["type"] == "confirmPaste" && canWrite {
+						err := input.Confirm(process, data["id"], client.Closed, sendInput, sendError)
+						if err != nil {
+							sendError(err)
+						}
 					} else if data["type"] == "ping" {
 						json, _ := json.Marshal(consolePing{"pong", data["id"]})
-						writeChannel <- json
+						client.Send(json)
 					} else {
 						json, _ := json.Marshal(consoleError{"error", "Invalid message type: " + data["type"]})
-						writeChannel <- json
+						client.Send(json)
 					}
 				} else {
 					json, _ := json.Marshal(consoleError{"error", "Invalid message format"})
-					writeChannel <- json
+					client.Send(json)
 				}
 			} else if canWrite {
-				connector.Info("server.console.input", "ip", GetIP(r), "user", user, "server", id,
-					"input", string(message))
-				process.SendCommand(string(message))
+				if lines := splitConsoleInput(string(message)); len(lines) > 1 {
+					sendError(errMultiLineInput)
+				} else if err := checkConsoleInput(process, &input.limiter, lines[0]); err != nil {
+					sendError(err)
+				} else if err := sendInput(lines[0]); err != nil {
+					sendError(err)
+				}
 			}
 		}
 	}
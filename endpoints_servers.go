@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/retrixe/octyne/system"
+	"github.com/tailscale/hujson"
+)
+
+var errServerExists = errors.New("a server with this name already exists")
+var errServerNotFound = errors.New("this server does not exist")
+var errServerDirectoryExists = errors.New("the server directory already exists")
+var errServerDirectoryNotFound = errors.New("the server directory does not exist")
+var errInvalidServerConfig = errors.New("the server directory and command are required")
+var errServerConfigChanged = errors.New("the server config was changed while the server was being cloned")
+
+// handleServerConfigError responds to the client with an appropriate error for server management.
+func handleServerConfigError(w http.ResponseWriter, id string, action string, err error) {
+	if errors.Is(err, errServerExists) {
+		httpError(w, "A server with this name already exists!", http.StatusConflict)
+	} else if errors.Is(err, errServerNotFound) {
+		httpError(w, "This server does not exist!", http.StatusNotFound)
+	} else if errors.Is(err, errServerDirectoryExists) {
+		httpError(w, "The server directory already exists!", http.StatusConflict)
+	} else if errors.Is(err, errServerDirectoryNotFound) {
+		httpError(w, "The server directory does not exist!", http.StatusBadRequest)
+	} else if errors.Is(err, errInvalidServerConfig) {
+		httpError(w, "The server directory and command are required!", http.StatusBadRequest)
+	} else if errors.Is(err, errBackupInProgress) {
+		httpError(w, "A backup or restore of this server is in progress!", http.StatusConflict)
+	} else if errors.Is(err, errServerConfigChanged) {
+		httpError(w, "The server config was changed while the server was being cloned!", http.StatusConflict)
+	} else {
+		log.Println("An error occurred when "+action, "("+id+")", err)
+		httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+	}
+}
+
+// isValidServerName checks if a server name can be safely used as a folder name.
+func isValidServerName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\")
+}
+
+// readServerConfig reads the config of a server from config.json, returning its fields as well.
+func readServerConfig(contents *hujson.Value, name string) (ServerConfig, map[string]json.RawMessage, error) {
+	var config ServerConfig
+	value := contents.Find(jsonPointer("servers", name))
+	if value == nil {
+		return config, nil, errServerNotFound
+	}
+	standardized, err := hujson.Standardize(value.Pack())
+	if err != nil {
+		return config, nil, err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(standardized, &fields); err != nil {
+		return config, nil, err
+	}
+	return config, fields, json.Unmarshal(standardized, &config)
+}
+
+// serverConfigPatch creates a JSON patch which applies the modified fields to the config of a server,
+// or creates the server if it doesn't exist, copying the config of cloneFrom if it isn't empty. It
+// returns the existing config of the server (or of cloneFrom), along with its new config.
+func serverConfigPatch(contents *hujson.Value, id string, cloneFrom string, fields map[string]json.RawMessage) (
+	[]map[string]interface{}, ServerConfig, ServerConfig, error) {
+	var newConfig ServerConfig
+	patch := make([]map[string]interface{}, 0)
+	existingConfig, existingFields, err := readServerConfig(contents, id)
+	if err == nil && cloneFrom != "" {
+		return nil, existingConfig, newConfig, errServerExists
+	} else if errors.Is(err, errServerNotFound) && cloneFrom != "" {
+		existingConfig, existingFields, err = readServerConfig(contents, cloneFrom)
+		if err != nil {
+			return nil, existingConfig, newConfig, err
+		}
+		patch = append(patch, map[string]interface{}{
+			"op": "copy", "from": jsonPointer("servers", cloneFrom), "path": jsonPointer("servers", id),
+		})
+	} else if errors.Is(err, errServerNotFound) {
+		existingFields = make(map[string]json.RawMessage)
+		if contents.Find(jsonPointer("servers")) == nil {
+			patch = append(patch, map[string]interface{}{"op": "add", "path": "/servers", "value": map[string]interface{}{}})
+		}
+		patch = append(patch, map[string]interface{}{
+			"op": "add", "path": jsonPointer("servers", id), "value": map[string]interface{}{},
+		})
+	} else if err != nil {
+		return nil, existingConfig, newConfig, err
+	}
+
+	// Apply the modified fields on top of the existing config.
+	for key, value := range fields {
+		_, exists := existingFields[key]
+		if string(value) == "null" && exists {
+			delete(existingFields, key)
+			patch = append(patch, map[string]interface{}{"op": "remove", "path": jsonPointer("servers", id, key)})
+		} else if string(value) != "null" {
+			existingFields[key] = value
+			patch = append(patch, map[string]interface{}{
+				"op": "add", "path": jsonPointer("servers", id, key), "value": value,
+			})
+		}
+	}
+	merged, err := json.Marshal(existingFields)
+	if err != nil {
+		return nil, existingConfig, newConfig, err
+	} else if err = json.Unmarshal(merged, &newConfig); err != nil {
+		return nil, existingConfig, newConfig, err
+	} else if newConfig.Directory == "" || newConfig.Command == "" {
+		return nil, existingConfig, newConfig, errInvalidServerConfig
+	}
+	return patch, existingConfig, newConfig, nil
+}
+
+// cloneServerDirectory copies the directory of a server to a new directory, which must not exist.
+// If copying fails, the new directory is deleted.
+func cloneServerDirectory(from string, to string) error {
+	stat, err := os.Stat(from)
+	if err != nil {
+		return err
+	} else if _, err := os.Stat(to); err == nil || !os.IsNotExist(err) {
+		return errServerDirectoryExists
+	} else if err = system.Copy(stat.Mode(), from, to); err != nil {
+		os.RemoveAll(to) // skipcq GSC-G104
+		return err
+	}
+	return nil
+}
+
+// PUT /server/{id}?createDirectory=true/false&cloneFrom=server
+func serverEndpointPut(connector *Connector, w http.ResponseWriter, r *http.Request, id string, user string) {
+	if !isValidServerName(id) {
+		httpError(w, "Invalid server name!", http.StatusBadRequest)
+		return
+	}
+	var body bytes.Buffer
+	_, err := body.ReadFrom(r.Body)
+	if err != nil {
+		httpError(w, "Failed to read body!", http.StatusBadRequest)
+		return
+	}
+	var fields map[string]json.RawMessage
+	var serverConfig ServerConfig
+	if json.Unmarshal(body.Bytes(), &fields) != nil || json.Unmarshal(body.Bytes(), &serverConfig) != nil {
+		httpError(w, "Invalid JSON body!", http.StatusBadRequest)
+		return
+	}
+	cloneFrom := r.URL.Query().Get("cloneFrom")
+	createDirectory := r.URL.Query().Get("createDirectory") == "true"
+
+	// The server directory is copied before config.json is locked, since copying may take a while,
+	// and the config is checked again while it is locked.
+	clonedDirectory := ""
+	if cloneFrom != "" {
+		ConfigJsonMutex.Lock()
+		contents, err := os.ReadFile(ConfigJsonPath)
+		ConfigJsonMutex.Unlock()
+		var existingConfig, newConfig ServerConfig
+		if err == nil {
+			var value hujson.Value
+			if value, err = hujson.Parse(contents); err == nil {
+				_, existingConfig, newConfig, err = serverConfigPatch(&value, id, cloneFrom, fields)
+			}
+		}
+		if err == nil {
+			err = cloneServerDirectory(existingConfig.Directory, newConfig.Directory)
+		}
+		if err != nil {
+			handleServerConfigError(w, id, "cloning server", err)
+			return
+		}
+		clonedDirectory = newConfig.Directory
+	}
+
+	created := false
+	config, err := PatchConfig(func(contents *hujson.Value) ([]byte, error) {
+		created = cloneFrom == "" && contents.Find(jsonPointer("servers", id)) == nil
+		patch, _, newConfig, err := serverConfigPatch(contents, id, cloneFrom, fields)
+		if err != nil {
+			return nil, err
+		}
+
+		// Check the cloned server directory, or create the server directory.
+		if cloneFrom != "" && newConfig.Directory != clonedDirectory {
+			return nil, errServerConfigChanged
+		} else if cloneFrom == "" && createDirectory {
+			if err = os.MkdirAll(newConfig.Directory, os.ModePerm); err != nil {
+				return nil, err
+			}
+		} else if stat, err := os.Stat(newConfig.Directory); err != nil || !stat.IsDir() {
+			return nil, errServerDirectoryNotFound
+		}
+		return json.Marshal(patch)
+	})
+	if err != nil {
+		if clonedDirectory != "" {
+			os.RemoveAll(clonedDirectory)
+		}
+		handleServerConfigError(w, id, "saving server config", err)
+		return
+	}
+	connector.UpdateConfig(&config)
+	if cloneFrom != "" {
+		connector.Info("server.clone", "ip", GetIP(r), "user", user, "server", id, "cloneFrom", cloneFrom)
+	} else if created {
+		connector.Info("server.create", "ip", GetIP(r), "user", user, "server", id, "config", fields)
+	} else {
+		connector.Info("server.edit", "ip", GetIP(r), "user", user, "server", id, "config", fields)
+	}
+	writeJsonStringRes(w, "{\"success\":true}")
+}
+
+// DELETE /server/{id}?directory=keep/delete/archive
+func serverEndpointDelete(connector *Connector, w http.ResponseWriter, r *http.Request, id string, user string) {
+	directory := r.URL.Query().Get("directory")
+	if directory == "" {
+		directory = "keep"
+	} else if directory != "keep" && directory != "delete" && directory != "archive" {
+		httpError(w, "Invalid directory option!", http.StatusBadRequest)
+		return
+	}
+	process, ok := connector.Processes.Load(id)
+	if directory != "keep" && ok && process.Online.Load() == 1 {
+		httpError(w, "The server must be stopped before its directory can be removed!", http.StatusConflict)
+		return
+	} else if _, loaded := backupsInProgress.LoadOrStore(id, true); loaded {
+		handleServerConfigError(w, id, "deleting server", errBackupInProgress)
+		return
+	}
+	defer backupsInProgress.Delete(id)
+
+	serverDir := ""
+	archive := ""
+	config, err := PatchConfig(func(contents *hujson.Value) ([]byte, error) {
+		serverConfig, _, err := readServerConfig(contents, id)
+		if err != nil {
+			return nil, err
+		}
+		serverDir = serverConfig.Directory
+		if directory == "archive" {
+			archive = filepath.Join(filepath.Dir(serverDir),
+				filepath.Base(serverDir)+"-"+time.Now().UTC().Format(backupTimeLayout)+".tar.gz")
+			file, err := os.OpenFile(archive, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+			if err != nil {
+				return nil, err
+			}
+			defer file.Close()
+			err = writeArchive(file, ".tar.gz", serverDir, []string{"."}, nil)
+			if err == nil {
+				err = file.Close()
+			}
+			if err != nil {
+				os.Remove(archive)
+				return nil, err
+			}
+		}
+		return json.Marshal([]map[string]interface{}{{"op": "remove", "path": jsonPointer("servers", id)}})
+	})
+	if err != nil {
+		handleServerConfigError(w, id, "deleting server", err)
+		return
+	}
+	connector.UpdateConfig(&config)
+	// Servers which aren't running are removed immediately, instead of whenever they next stop.
+	if ok && process.Online.Load() != 1 {
+		process.SendConsoleOutput("[Octyne] Server " + id + " has been removed.")
+		go connector.RemoveProcess(id)
+	}
+	if directory != "keep" {
+		err = os.RemoveAll(serverDir)
+		if err != nil {
+			handleServerConfigError(w, id, "deleting server directory", err)
+			return
+		}
+	}
+	connector.Info("server.delete", "ip", GetIP(r), "user", user, "server", id, "directory", directory)
+	res := map[string]interface{}{"success": true}
+	if archive != "" {
+		res["archive"] = archive
+	}
+	writeJsonStructRes(w, res) // skipcq GSC-G104
+}
+
+// GET /templates
+func templatesEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		httpError(w, "Only GET is allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	// Check with authenticator.
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, "config.view")
+	if user == "" || !hasPerm {
+		return
+	}
+	templates, err := listTemplates(connector.Config.Load().Templates.Directory)
+	if err != nil {
+		log.Println("An error occurred when listing templates!", err)
+		httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+		return
+	}
+	writeJsonStructRes(w, map[string]interface{}{"templates": templates}) // skipcq GSC-G104
+}
+
+// POST /templates/{name}
+type templateRequestBody struct {
+	Server    string            `json:"server"`
+	Directory string            `json:"directory"`
+	Variables map[string]string `json:"variables"`
+}
+
+func templateEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if r.Method != "POST" {
+		httpError(w, "Only POST is allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	// Check with authenticator.
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, "config.edit")
+	if user == "" || !hasPerm {
+		return
+	}
+	var body templateRequestBody
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		httpError(w, "Invalid JSON body!", http.StatusBadRequest)
+		return
+	} else if !isValidServerName(body.Server) {
+		httpError(w, "Invalid server name!", http.StatusBadRequest)
+		return
+	} else if body.Directory == "" {
+		httpError(w, "The server directory is required!", http.StatusBadRequest)
+		return
+	}
+	templatesDir := connector.Config.Load().Templates.Directory
+	template, err := readTemplate(templatesDir, name)
+	if errors.Is(err, errTemplateNotFound) {
+		httpError(w, "This template does not exist!", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("An error occurred when reading template "+name+"!", err)
+		httpError(w, "Internal Server Error!", http.StatusInternalServerError)
+		return
+	}
+	variables, err := template.variables(body.Server, body.Directory, body.Variables)
+	if err != nil {
+		httpError(w, "Invalid template variables: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	fields, err := template.serverConfig(body.Directory, variables)
+	if errors.Is(err, errInvalidTemplateConfig) {
+		httpError(w, "Invalid template variables: "+err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		handleServerConfigError(w, body.Server, "creating server from template "+name, err)
+		return
+	}
+
+	copied := false
+	config, err := PatchConfig(func(contents *hujson.Value) ([]byte, error) {
+		if contents.Find(jsonPointer("servers", body.Server)) != nil {
+			return nil, errServerExists
+		} else if _, err := os.Stat(body.Directory); err == nil || !os.IsNotExist(err) {
+			return nil, errServerDirectoryExists
+		}
+		copied = true
+		err := template.copyFiles(filepath.Join(templatesDir, name), body.Directory, variables)
+		if err != nil {
+			return nil, err
+		}
+		patch := make([]map[string]interface{}, 0)
+		if contents.Find(jsonPointer("servers")) == nil {
+			patch = append(patch, map[string]interface{}{"op": "add", "path": "/servers", "value": map[string]interface{}{}})
+		}
+		patch = append(patch, map[string]interface{}{
+			"op": "add", "path": jsonPointer("servers", body.Server), "value": fields,
+		})
+		return json.Marshal(patch)
+	})
+	if err != nil {
+		if copied {
+			os.RemoveAll(body.Directory)
+		}
+		handleServerConfigError(w, body.Server, "creating server from template "+name, err)
+		return
+	}
+	connector.UpdateConfig(&config)
+	connector.Info("server.create", "ip", GetIP(r), "user", user, "server", body.Server,
+		"template", name, "variables", body.Variables)
+	writeJsonStringRes(w, "{\"success\":true}")
+}
+
+// GET /server/{id}/export?ticket=ticket
+func serverExportEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method != "GET" {
+		httpError(w, "Only GET is allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	// Check with authenticator.
+	user, hasPerm := connector.validateTicketWithPermAndReject(w, r, "server<"+id+">.export")
+	if user == "" || !hasPerm {
+		return
+	}
+	// Read the server's config from config.json, so that comments aren't lost.
+	ConfigJsonMutex.Lock()
+	contents, err := os.ReadFile(ConfigJsonPath)
+	ConfigJsonMutex.Unlock()
+	var serverConfig ServerConfig
+	var fields map[string]json.RawMessage
+	if err == nil {
+		var value hujson.Value
+		if value, err = hujson.Parse(contents); err == nil {
+			serverConfig, fields, err = readServerConfig(&value, id)
+		}
+	}
+	if err != nil {
+		handleServerConfigError(w, id, "exporting server", err)
+		return
+	}
+	// Send the response.
+	w.Header().Set("Content-Disposition", attachmentDisposition(id+".tar.gz"))
+	w.Header().Set("Content-Type", "application/gzip")
+	connector.Info("server.export", "ip", GetIP(r), "user", user, "server", id)
+	err = writeServerBundle(w, id, serverConfig.Directory, fields)
+	if err != nil {
+		log.Println("An error occurred when exporting server", "("+id+")", err)
+	}
+}
+
+// POST /servers/import?name=name&directory=directory&onConflict=fail/rename&remap=remap
+func serversImportEndpoint(connector *Connector, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		httpError(w, "Only POST is allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+	// Check with authenticator.
+	user, hasPerm := connector.ValidateWithPermAndReject(w, r, "config.edit")
+	if user == "" || !hasPerm {
+		return
+	}
+	remap := make(map[string]string)
+	if r.URL.Query().Get("remap") != "" && json.Unmarshal([]byte(r.URL.Query().Get("remap")), &remap) != nil {
+		httpError(w, "Invalid remap query parameter!", http.StatusBadRequest)
+		return
+	}
+	onConflict := r.URL.Query().Get("onConflict")
+	if onConflict != "" && onConflict != "fail" && onConflict != "rename" {
+		httpError(w, "Invalid onConflict query parameter!", http.StatusBadRequest)
+		return
+	}
+	manifest, archive, err := readServerBundleManifest(r.Body)
+	if errors.Is(err, errInvalidServerBundle) {
+		httpError(w, "Invalid server bundle!", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		httpError(w, "Failed to read body!", http.StatusBadRequest)
+		return
+	}
+
+	// Determine the name and directory of the imported server.
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = manifest.Name
+	}
+	directory := r.URL.Query().Get("directory")
+	if directory == "" {
+		directory = remapPath(manifest.Directory, remap)
+	}
+	if !isValidServerName(name) {
+		httpError(w, "Invalid server name!", http.StatusBadRequest)
+		return
+	} else if directory == "" {
+		httpError(w, "The server directory is required!", http.StatusBadRequest)
+		return
+	} else if directory, err = filepath.Abs(directory); err != nil {
+		httpError(w, "Invalid server directory!", http.StatusBadRequest)
+		return
+	}
+	if onConflict == "rename" {
+		config, err := ReadConfig()
+		if err != nil {
+			handleServerConfigError(w, name, "importing server", err)
+			return
+		}
+		newName, newDirectory := name, directory
+		for suffix := 2; ; suffix++ {
+			if _, exists := config.Servers[newName]; !exists {
+				break
+			}
+			newName = name + "-" + strconv.Itoa(suffix)
+		}
+		for suffix := 2; ; suffix++ {
+			if _, err := os.Stat(newDirectory); os.IsNotExist(err) {
+				break
+			}
+			newDirectory = directory + "-" + strconv.Itoa(suffix)
+		}
+		name, directory = newName, newDirectory
+	}
+	fields := make(map[string]json.RawMessage)
+	for key, value := range manifest.Config {
+		var decoded interface{}
+		if err = json.Unmarshal(value, &decoded); err == nil {
+			fields[key], err = json.Marshal(remapConfigPaths(decoded, remap))
+		}
+		if err != nil {
+			httpError(w, "Invalid server bundle!", http.StatusBadRequest)
+			return
+		}
+	}
+	fields["directory"], _ = json.Marshal(directory)
+
+	// Extract the bundle next to the server directory, then move it into place.
+	err = os.MkdirAll(filepath.Dir(directory), os.ModePerm)
+	if err != nil {
+		handleServerConfigError(w, name, "importing server", err)
+		return
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(directory), ".octyne-import-")
+	if err != nil {
+		handleServerConfigError(w, name, "importing server", err)
+		return
+	}
+	defer os.RemoveAll(tmp)
+	err = system.ExtractTar(archive, tmp)
+	if err != nil {
+		httpError(w, "Failed to extract server bundle!", http.StatusBadRequest)
+		return
+	}
+	moved := false
+	config, err := PatchConfig(func(contents *hujson.Value) ([]byte, error) {
+		if contents.Find(jsonPointer("servers", name)) != nil {
+			return nil, errServerExists
+		} else if _, err := os.Stat(directory); err == nil || !os.IsNotExist(err) {
+			return nil, errServerDirectoryExists
+		} else if err = os.Rename(filepath.Join(tmp, manifest.Files), directory); err != nil {
+			return nil, err
+		}
+		moved = true
+		patch := make([]map[string]interface{}, 0)
+		if contents.Find(jsonPointer("servers")) == nil {
+			patch = append(patch, map[string]interface{}{"op": "add", "path": "/servers", "value": map[string]interface{}{}})
+		}
+		patch = append(patch, map[string]interface{}{
+			"op": "add", "path": jsonPointer("servers", name), "value": fields,
+		})
+		return json.Marshal(patch)
+	})
+	if err != nil {
+		if moved {
+			os.RemoveAll(directory)
+		}
+		handleServerConfigError(w, name, "importing server", err)
+		return
+	}
+	connector.UpdateConfig(&config)
+	connector.Info("server.import", "ip", GetIP(r), "user", user, "server", name,
+		"directory", directory, "originalName", manifest.Name)
+	writeJsonStructRes(w, map[string]interface{}{ // skipcq GSC-G104
+		"success": true, "name": name, "directory": directory,
+	})
+}
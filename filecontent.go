@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFileContentSize is the maximum size of a file which can be read and written as text.
+const maxFileContentSize = 10 * 1024 * 1024
+
+// fileETag returns a strong ETag for the contents of a file and the time it was last modified.
+func fileETag(contents []byte, modTime time.Time) string {
+	hash := sha256.Sum256(contents)
+	return "\"" + hex.EncodeToString(hash[:16]) + "-" + strconv.FormatInt(modTime.UnixNano(), 36) + "\""
+}
+
+// etagMatches checks whether an If-Match header matches an ETag, using strong comparison.
+func etagMatches(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFileAtomic writes data to a hidden temporary file in the same folder as a file, then renames
+// it over the file, so the file is never left partially written. The permissions of the existing
+// file are kept, and new files are created with 0644 permissions.
+func writeFileAtomic(filePath string, data []byte) error {
+	mode := os.FileMode(0644)
+	if stat, err := os.Stat(filePath); err == nil {
+		mode = stat.Mode().Perm()
+	}
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // Fails if the rename succeeded: skipcq GSC-G104
+	if _, err := file.Write(data); err != nil {
+		file.Close() // skipcq GSC-G104
+		return err
+	} else if err := file.Sync(); err != nil {
+		file.Close() // skipcq GSC-G104
+		return err
+	} else if err := file.Close(); err != nil {
+		return err
+	} else if err := os.Chmod(file.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filePath)
+}
+
+// attachmentDisposition returns a Content-Disposition header for downloading a file with a name.
+func attachmentDisposition(name string) string {
+	// FormatMediaType quotes the file name, and encodes it per RFC 2231 if it isn't ASCII.
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": name})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	return disposition
+}
+
+// serveDownload serves a file as an attachment with http.ServeContent, which handles HEAD requests,
+// byte ranges and conditional requests. The ETag is based on the size of the file and the time it
+// was last modified, since hashing large files on every request would be too slow.
+func serveDownload(w http.ResponseWriter, r *http.Request, file *os.File, stat os.FileInfo, name string) {
+	w.Header().Set("Content-Disposition", attachmentDisposition(name))
+	w.Header().Set("ETag", "\""+strconv.FormatInt(stat.ModTime().UnixNano(), 36)+"-"+
+		strconv.FormatInt(stat.Size(), 36)+"\"")
+	http.ServeContent(w, r, name, stat.ModTime(), file)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CommandHistoryEntry is a command sent to the console of a server by a user.
+type CommandHistoryEntry struct {
+	Time    int64  `json:"time"` // Unix time in milliseconds.
+	User    string `json:"user"`
+	IP      string `json:"ip"`
+	Command string `json:"command"`
+}
+
+// CommandHistory stores the commands sent to the console of a server in memory, and persists them
+// to a JSON Lines file in the server's console log folder. Only the newest entries are kept.
+type CommandHistory struct {
+	mutex      sync.Mutex
+	entries    []CommandHistoryEntry
+	file       string
+	loaded     bool
+	maxEntries int
+}
+
+// NewCommandHistory creates a CommandHistory for the server with the given name. If command
+// history is disabled, commands are not recorded.
+func NewCommandHistory(config LoggingConfig, name string) *CommandHistory {
+	history := &CommandHistory{}
+	history.SetConfig(config, name)
+	return history
+}
+
+// SetConfig updates the file and limits of the history.
+func (h *CommandHistory) SetConfig(config LoggingConfig, name string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	file := ""
+	if config.Enabled && config.History.Enabled {
+		file = filepath.Join(consoleLogDirectory(config, name), "history.jsonl")
+	}
+	if file != h.file {
+		h.file = file
+		h.entries = nil
+		h.loaded = false
+	}
+	h.maxEntries = config.History.MaxEntries
+	if h.maxEntries <= 0 {
+		h.maxEntries = defaultConfig.Logging.History.MaxEntries
+	}
+}
+
+// load reads the history from its file if it hasn't been read yet. The mutex must be held.
+func (h *CommandHistory) load() {
+	if h.loaded || h.file == "" {
+		return
+	}
+	h.loaded = true
+	file, err := os.Open(h.file)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Println("Failed to read command history from "+h.file+"!", err)
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 2*1024*1024)
+	for scanner.Scan() {
+		var entry CommandHistoryEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			h.entries = append(h.entries, entry)
+		}
+	}
+	if len(h.entries) > h.maxEntries {
+		h.entries = h.entries[len(h.entries)-h.maxEntries:]
+	}
+}
+
+// Add records a command sent by a user, and persists it to the history file.
+func (h *CommandHistory) Add(user string, ip string, command string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.file == "" {
+		return nil
+	}
+	h.load()
+	entry := CommandHistoryEntry{Time: time.Now().UnixMilli(), User: user, IP: ip, Command: command}
+	h.entries = append(h.entries, entry)
+	// Rewrite the file once it has 10% more entries than the limit, instead of on every command.
+	if len(h.entries) > h.maxEntries+h.maxEntries/10 {
+		h.entries = append([]CommandHistoryEntry(nil), h.entries[len(h.entries)-h.maxEntries:]...)
+		return h.rewrite()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(h.file), 0755)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(h.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// rewrite replaces the history file with the entries in memory. The mutex must be held.
+func (h *CommandHistory) rewrite() error {
+	file, err := os.Create(h.file + "~")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, entry := range h.entries {
+		line, err := json.Marshal(entry)
+		if err == nil {
+			writer.Write(append(line, '\n')) // skipcq GSC-G104
+		}
+	}
+	err = writer.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(h.file + "~") // skipcq GSC-G104
+		return err
+	}
+	return os.Rename(h.file+"~", h.file)
+}
+
+// Query returns the newest entries in the history matching the given user and time range, from
+// oldest to newest. Empty or zero values match any user or time.
+func (h *CommandHistory) Query(user string, from int64, to int64, limit int) []CommandHistoryEntry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.load()
+	entries := make([]CommandHistoryEntry, 0)
+	for i := len(h.entries) - 1; i >= 0 && len(entries) < limit; i-- {
+		entry := h.entries[i]
+		if (user == "" || entry.User == user) &&
+			(from == 0 || entry.Time >= from) && (to == 0 || entry.Time <= to) {
+			entries = append(entries, entry)
+		}
+	}
+	slices.Reverse(entries)
+	return entries
+}
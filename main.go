@@ -88,6 +88,7 @@ func main() {
 	for _, name := range servers {
 		go CreateProcess(name, config.Servers[name], connector)
 	}
+	go connector.RunScheduler()
 
 	// Listen.
 	apiPort := portToString(config.Port, defaultConfig.Port)
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/retrixe/octyne/minecraft"
+)
+
+// minecraftStatusTTL is how long the status of a Minecraft server is cached for.
+const minecraftStatusTTL = 5 * time.Second
+
+// minecraftTimeout is the timeout for getting the status of a Minecraft server.
+const minecraftTimeout = 2 * time.Second
+
+// MinecraftStatus is the status of a Minecraft server, or the error which occurred getting it.
+type MinecraftStatus struct {
+	*minecraft.Status
+	Error string `json:"error,omitempty"`
+}
+
+// minecraftStatusCache caches the status of a Minecraft server.
+type minecraftStatusCache struct {
+	mutex   sync.Mutex
+	status  *MinecraftStatus
+	updated time.Time
+}
+
+// MinecraftStatus returns the status of the server if it is a Minecraft server which is online,
+// and nil otherwise. The status is cached for a few seconds.
+func (process *ExposedProcess) MinecraftStatus() *MinecraftStatus {
+	process.ServerConfigMutex.RLock()
+	config := process.Minecraft
+	process.ServerConfigMutex.RUnlock()
+	if config == nil || process.Online.Load() != 1 {
+		return nil
+	}
+	cache := &process.MinecraftStatusCache
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.status != nil && time.Since(cache.updated) < minecraftStatusTTL {
+		return cache.status
+	}
+	cache.status = getMinecraftStatus(*config)
+	cache.updated = time.Now()
+	return cache.status
+}
+
+// getMinecraftStatus gets the status of a Minecraft server using Server List Ping, along with the
+// full player list using Query if it is enabled.
+func getMinecraftStatus(config MinecraftConfig) *MinecraftStatus {
+	host := config.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	port := config.Port
+	if port == 0 {
+		port = 25565
+	}
+	status, err := minecraft.Ping(net.JoinHostPort(host, strconv.Itoa(int(port))), minecraftTimeout)
+	if err != nil {
+		return &MinecraftStatus{Error: err.Error()}
+	}
+	if config.Query {
+		queryPort := config.QueryPort
+		if queryPort == 0 {
+			queryPort = port
+		}
+		// If Query fails, the sample of players from Server List Ping is used instead.
+		query, err := minecraft.Query(net.JoinHostPort(host, strconv.Itoa(int(queryPort))), minecraftTimeout)
+		if err == nil {
+			status.OnlinePlayers = query.OnlinePlayers
+			status.Players = query.Players
+		}
+	}
+	return &MinecraftStatus{Status: status}
+}
@@ -0,0 +1,255 @@
+// Package minecraft implements the Server List Ping and Query protocols used to get the status of
+// Minecraft servers.
+package minecraft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxResponseLength is the maximum length of a Server List Ping response, which may include a
+// server icon.
+const maxResponseLength = 1024 * 1024
+
+var ErrInvalidResponse = errors.New("invalid response from minecraft server")
+
+// Status is the status of a Minecraft server.
+type Status struct {
+	MOTD          string   `json:"motd"`
+	Version       string   `json:"version"`
+	Protocol      int      `json:"protocol"`
+	OnlinePlayers int      `json:"onlinePlayers"`
+	MaxPlayers    int      `json:"maxPlayers"`
+	Players       []string `json:"players"`
+}
+
+// chatComponent is a text component used in the description of a server, which is either a
+// string or an object with text and extra components.
+type chatComponent struct {
+	Text  string          `json:"text"`
+	Extra []chatComponent `json:"extra"`
+}
+
+// UnmarshalJSON unmarshals a chat component from either a string or an object.
+func (c *chatComponent) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &c.Text)
+	}
+	type alias chatComponent // Prevent recursive calls to UnmarshalJSON.
+	return json.Unmarshal(data, (*alias)(c))
+}
+
+// String returns the text of the component and its extra components.
+func (c chatComponent) String() string {
+	text := c.Text
+	for _, extra := range c.Extra {
+		text += extra.String()
+	}
+	return text
+}
+
+type pingResponse struct {
+	Version struct {
+		Name     string `json:"name"`
+		Protocol int    `json:"protocol"`
+	} `json:"version"`
+	Players struct {
+		Max    int `json:"max"`
+		Online int `json:"online"`
+		Sample []struct {
+			Name string `json:"name"`
+		} `json:"sample"`
+	} `json:"players"`
+	Description chatComponent `json:"description"`
+}
+
+// StripFormatting removes legacy formatting codes (e.g. §a) from text.
+func StripFormatting(text string) string {
+	var builder strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == '§' {
+			i++ // Skip the formatting code as well.
+			continue
+		}
+		builder.WriteRune(runes[i])
+	}
+	return builder.String()
+}
+
+func appendVarInt(buf []byte, value int32) []byte {
+	unsigned := uint32(value)
+	for unsigned >= 0x80 {
+		buf = append(buf, byte(unsigned)|0x80)
+		unsigned >>= 7
+	}
+	return append(buf, byte(unsigned))
+}
+
+func readVarInt(reader io.ByteReader) (int32, error) {
+	var value uint32
+	for i := 0; i < 5; i++ {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint32(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return int32(value), nil
+		}
+	}
+	return 0, ErrInvalidResponse
+}
+
+// appendPacket appends a packet with its length prefix to buf.
+func appendPacket(buf []byte, packet []byte) []byte {
+	return append(appendVarInt(buf, int32(len(packet))), packet...)
+}
+
+// Ping gets the status of a Minecraft server using the Server List Ping protocol. Only a sample
+// of the online players is returned by most servers.
+func Ping(address string, timeout time.Duration) (*Status, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout)) // skipcq GSC-G104
+	// Send the handshake with the next state set to status, followed by a status request.
+	handshake := appendVarInt([]byte{0x00}, -1) // Protocol version, -1 when pinging.
+	handshake = appendVarInt(handshake, int32(len(host)))
+	handshake = append(handshake, host...)
+	handshake = binary.BigEndian.AppendUint16(handshake, uint16(port))
+	handshake = appendVarInt(handshake, 1)
+	request := appendPacket(appendPacket(nil, handshake), []byte{0x00})
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	// Read the status response.
+	reader := bufio.NewReader(conn)
+	length, err := readVarInt(reader)
+	if err != nil {
+		return nil, err
+	} else if length <= 0 || length > maxResponseLength {
+		return nil, ErrInvalidResponse
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(reader, packet); err != nil {
+		return nil, err
+	}
+	packetReader := bytes.NewReader(packet)
+	if id, err := readVarInt(packetReader); err != nil || id != 0x00 {
+		return nil, ErrInvalidResponse
+	}
+	jsonLength, err := readVarInt(packetReader)
+	if err != nil || jsonLength < 0 || int(jsonLength) > packetReader.Len() {
+		return nil, ErrInvalidResponse
+	}
+	var response pingResponse
+	if err := json.Unmarshal(packet[len(packet)-packetReader.Len():][:jsonLength], &response); err != nil {
+		return nil, ErrInvalidResponse
+	}
+	status := &Status{
+		MOTD:          StripFormatting(response.Description.String()),
+		Version:       response.Version.Name,
+		Protocol:      response.Version.Protocol,
+		OnlinePlayers: response.Players.Online,
+		MaxPlayers:    response.Players.Max,
+		Players:       make([]string, 0, len(response.Players.Sample)),
+	}
+	for _, player := range response.Players.Sample {
+		status.Players = append(status.Players, player.Name)
+	}
+	return status, nil
+}
+
+// QueryStatus is the status of a Minecraft server returned by the Query protocol.
+type QueryStatus struct {
+	MOTD          string
+	Version       string
+	Plugins       string
+	Map           string
+	OnlinePlayers int
+	MaxPlayers    int
+	Players       []string
+}
+
+// Query gets the full status of a Minecraft server using the Query protocol, which must be enabled
+// on the server (enable-query in server.properties). Unlike Ping, all online players are returned.
+func Query(address string, timeout time.Duration) (*QueryStatus, error) {
+	conn, err := net.DialTimeout("udp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout)) // skipcq GSC-G104
+	sessionID := uint32(time.Now().UnixNano()) & 0x0f0f0f0f
+	buf := make([]byte, 65536)
+	// Request a challenge token, which is returned as a null-terminated decimal string.
+	request := binary.BigEndian.AppendUint32([]byte{0xfe, 0xfd, 0x09}, sessionID)
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	} else if n < 6 || buf[0] != 0x09 || binary.BigEndian.Uint32(buf[1:5]) != sessionID {
+		return nil, ErrInvalidResponse
+	}
+	token, err := strconv.ParseInt(string(bytes.TrimRight(buf[5:n], "\x00")), 10, 32)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	// Request the full stat, which is requested by padding the request with 4 bytes.
+	request = binary.BigEndian.AppendUint32([]byte{0xfe, 0xfd, 0x00}, sessionID)
+	request = binary.BigEndian.AppendUint32(request, uint32(int32(token)))
+	request = append(request, 0x00, 0x00, 0x00, 0x00)
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	n, err = conn.Read(buf)
+	if err != nil {
+		return nil, err
+	} else if n < 16 || buf[0] != 0x00 || binary.BigEndian.Uint32(buf[1:5]) != sessionID {
+		return nil, ErrInvalidResponse
+	}
+	// Skip the padding, then read key/value pairs up to an empty key, followed by padding and
+	// player names up to an empty name.
+	fields := bytes.Split(buf[16:n], []byte{0x00})
+	values := make(map[string]string)
+	i := 0
+	for ; i+1 < len(fields) && len(fields[i]) > 0; i += 2 {
+		values[string(fields[i])] = string(fields[i+1])
+	}
+	status := &QueryStatus{
+		MOTD:    StripFormatting(values["hostname"]),
+		Version: values["version"],
+		Plugins: values["plugins"],
+		Map:     values["map"],
+	}
+	status.OnlinePlayers, _ = strconv.Atoi(values["numplayers"])
+	status.MaxPlayers, _ = strconv.Atoi(values["maxplayers"])
+	// The player count comes from the server, so it isn't trusted as a capacity hint.
+	status.Players = make([]string, 0, max(0, min(status.OnlinePlayers, 1000)))
+	// After the empty key, the padding is "\x01player_\x00\x00".
+	for i += 3; i < len(fields) && len(fields[i]) > 0; i++ {
+		status.Players = append(status.Players, string(fields[i]))
+	}
+	return status, nil
+}
@@ -0,0 +1,139 @@
+package minecraft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+const testTimeout = 2 * time.Second
+
+// serveFakePing accepts a single Server List Ping connection and replies with the JSON response.
+func serveFakePing(t *testing.T, response string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		// Read the handshake and status request packets.
+		for i := 0; i < 2; i++ {
+			length, err := readVarInt(reader)
+			if err != nil {
+				return
+			}
+			if _, err := io.CopyN(io.Discard, reader, int64(length)); err != nil {
+				return
+			}
+		}
+		packet := appendVarInt([]byte{0x00}, int32(len(response)))
+		packet = append(packet, response...)
+		conn.Write(appendPacket(nil, packet))
+	}()
+	return listener.Addr().String()
+}
+
+func TestPing(t *testing.T) {
+	address := serveFakePing(t, `{"version":{"name":"1.21","protocol":767},`+
+		`"players":{"max":20,"online":2,"sample":[{"name":"a"},{"name":"b"}]},`+
+		`"description":{"text":"§aHello ","extra":[{"text":"World"}]}}`)
+	status, err := Ping(address, testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.MOTD != "Hello World" || status.Version != "1.21" || status.Protocol != 767 ||
+		status.OnlinePlayers != 2 || status.MaxPlayers != 20 || !slices.Equal(status.Players, []string{"a", "b"}) {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestPingInvalidResponse(t *testing.T) {
+	address := serveFakePing(t, `not json`)
+	if _, err := Ping(address, testTimeout); err != ErrInvalidResponse {
+		t.Errorf("expected ErrInvalidResponse, got %v", err)
+	}
+}
+
+// serveFakeQuery replies to a challenge request and a full stat request with the given values.
+func serveFakeQuery(t *testing.T, values []string, players []string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			} else if n < 7 {
+				continue
+			}
+			sessionID := binary.BigEndian.Uint32(buf[3:7])
+			if buf[2] == 0x09 {
+				response := binary.BigEndian.AppendUint32([]byte{0x09}, sessionID)
+				conn.WriteTo(append(response, "12345\x00"...), addr)
+				continue
+			}
+			response := binary.BigEndian.AppendUint32([]byte{0x00}, sessionID)
+			response = append(response, "splitnum\x00\x80\x00"...)
+			for _, value := range values {
+				response = append(response, value+"\x00"...)
+			}
+			response = append(response, "\x00\x01player_\x00\x00"...)
+			for _, player := range players {
+				response = append(response, player+"\x00"...)
+			}
+			conn.WriteTo(append(response, 0x00), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestQuery(t *testing.T) {
+	address := serveFakeQuery(t, []string{
+		"hostname", "§bA Server", "version", "1.21", "plugins", "Paper", "map", "world",
+		"numplayers", "2", "maxplayers", "20",
+	}, []string{"a", "b"})
+	status, err := Query(address, testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.MOTD != "A Server" || status.Version != "1.21" || status.Plugins != "Paper" ||
+		status.Map != "world" || status.OnlinePlayers != 2 || status.MaxPlayers != 20 ||
+		!slices.Equal(status.Players, []string{"a", "b"}) {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestQueryInvalidPlayerCount(t *testing.T) {
+	for _, count := range []string{"-1", "2147483647"} {
+		address := serveFakeQuery(t, []string{"numplayers", count}, []string{"a"})
+		status, err := Query(address, testTimeout)
+		if err != nil {
+			t.Fatal(err)
+		} else if !slices.Equal(status.Players, []string{"a"}) {
+			t.Errorf("unexpected players for numplayers %s: %v", count, status.Players)
+		}
+	}
+}
+
+func TestStripFormatting(t *testing.T) {
+	if text := StripFormatting("§l§cRed§r text§"); text != "Red text" {
+		t.Errorf("unexpected text: %q", text)
+	}
+	if text := StripFormatting("plain"); text != "plain" {
+		t.Error("plain text was changed")
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pluginFolders are the folders of a server which plugins and mods are installed in.
+var pluginFolders = []string{"plugins", "mods"}
+
+// disabledPluginFolder is the folder inside a plugin folder which disabled plugins are moved to.
+const disabledPluginFolder = "disabled"
+
+// maxPluginMetadataSize is the maximum size of a plugin metadata file or nested mod jar.
+const maxPluginMetadataSize = 16 * 1024 * 1024
+
+// builtinDependencies are dependencies provided by the server or mod loader itself.
+var builtinDependencies = []string{"minecraft", "java", "fabricloader", "fabric-loader", "quilt_loader"}
+
+var errNoPluginMetadata = errors.New("no plugin.yml, paper-plugin.yml or fabric.mod.json found")
+
+// PluginInfo is a plugin or mod jar installed on a server, along with its metadata.
+type PluginInfo struct {
+	File                string   `json:"file"`
+	Folder              string   `json:"folder"`
+	Enabled             bool     `json:"enabled"`
+	Size                int64    `json:"size"`
+	Type                string   `json:"type,omitempty"` // bukkit, paper or fabric
+	ID                  string   `json:"id,omitempty"`
+	Name                string   `json:"name,omitempty"`
+	Version             string   `json:"version,omitempty"`
+	Description         string   `json:"description,omitempty"`
+	Authors             []string `json:"authors"`
+	Dependencies        []string `json:"dependencies"`
+	SoftDependencies    []string `json:"softDependencies"`
+	MissingDependencies []string `json:"missingDependencies"`
+	Error               string   `json:"error,omitempty"`
+
+	provides []string // IDs provided by the plugin, e.g. nested Fabric mods.
+}
+
+type bukkitPluginYml struct {
+	Name        string    `yaml:"name"`
+	Version     yaml.Node `yaml:"version"` // Versions like 2.20 must not be parsed as numbers.
+	Description string    `yaml:"description"`
+	Author      string    `yaml:"author"`
+	Authors     []string  `yaml:"authors"`
+	Depend      []string  `yaml:"depend"`
+	SoftDepend  []string  `yaml:"softdepend"`
+	Provides    []string  `yaml:"provides"`
+	// Dependencies are only used by paper-plugin.yml.
+	Dependencies struct {
+		Server map[string]struct {
+			Required *bool `yaml:"required"`
+		} `yaml:"server"`
+	} `yaml:"dependencies"`
+}
+
+type fabricModJson struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	Description string            `json:"description"`
+	Authors     []json.RawMessage `json:"authors"` // Either names or objects with a name.
+	Depends     map[string]any    `json:"depends"`
+	Recommends  map[string]any    `json:"recommends"`
+	Suggests    map[string]any    `json:"suggests"`
+	Provides    []string          `json:"provides"`
+	Jars        []struct {
+		File string `json:"file"`
+	} `json:"jars"`
+}
+
+// readZipFile reads a file inside a zip archive, returning nil if it doesn't exist.
+func readZipFile(archive *zip.Reader, name string) ([]byte, error) {
+	file, err := archive.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, maxPluginMetadataSize))
+}
+
+// readPluginMetadata reads the metadata of a plugin or mod jar from its plugin.yml,
+// paper-plugin.yml or fabric.mod.json.
+func readPluginMetadata(plugin *PluginInfo, archive *zip.Reader) error {
+	if data, err := readZipFile(archive, "paper-plugin.yml"); err != nil {
+		return err
+	} else if data != nil {
+		plugin.Type = "paper"
+		return readBukkitPluginYml(plugin, data)
+	}
+	if data, err := readZipFile(archive, "plugin.yml"); err != nil {
+		return err
+	} else if data != nil {
+		plugin.Type = "bukkit"
+		return readBukkitPluginYml(plugin, data)
+	}
+	if data, err := readZipFile(archive, "fabric.mod.json"); err != nil {
+		return err
+	} else if data != nil {
+		plugin.Type = "fabric"
+		return readFabricModJson(plugin, archive, data, 0)
+	}
+	return errNoPluginMetadata
+}
+
+func readBukkitPluginYml(plugin *PluginInfo, data []byte) error {
+	var metadata bukkitPluginYml
+	if err := yaml.Unmarshal(data, &metadata); err != nil {
+		return err
+	}
+	plugin.ID = metadata.Name
+	plugin.Name = metadata.Name
+	plugin.Version = metadata.Version.Value
+	plugin.Description = metadata.Description
+	if metadata.Author != "" {
+		plugin.Authors = append(plugin.Authors, metadata.Author)
+	}
+	plugin.Authors = append(plugin.Authors, metadata.Authors...)
+	plugin.Dependencies = append(plugin.Dependencies, metadata.Depend...)
+	plugin.SoftDependencies = append(plugin.SoftDependencies, metadata.SoftDepend...)
+	for name, dependency := range metadata.Dependencies.Server {
+		if dependency.Required == nil || *dependency.Required {
+			plugin.Dependencies = append(plugin.Dependencies, name)
+		} else {
+			plugin.SoftDependencies = append(plugin.SoftDependencies, name)
+		}
+	}
+	slices.Sort(plugin.Dependencies)
+	slices.Sort(plugin.SoftDependencies)
+	plugin.provides = append(metadata.Provides, metadata.Name)
+	return nil
+}
+
+// readFabricModJson reads the metadata of a Fabric mod, along with the IDs of the mods nested
+// inside it, which are provided by the mod.
+func readFabricModJson(plugin *PluginInfo, archive *zip.Reader, data []byte, depth int) error {
+	var metadata fabricModJson
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return err
+	}
+	plugin.provides = append(plugin.provides, metadata.ID)
+	plugin.provides = append(plugin.provides, metadata.Provides...)
+	for _, jar := range metadata.Jars {
+		if depth >= 2 {
+			break
+		}
+		nestedData, err := readZipFile(archive, jar.File)
+		if err != nil || nestedData == nil {
+			continue
+		}
+		nested, err := zip.NewReader(bytes.NewReader(nestedData), int64(len(nestedData)))
+		if err != nil {
+			continue
+		}
+		if nestedMetadata, err := readZipFile(nested, "fabric.mod.json"); err == nil && nestedMetadata != nil {
+			nestedPlugin := &PluginInfo{}
+			if readFabricModJson(nestedPlugin, nested, nestedMetadata, depth+1) == nil {
+				plugin.provides = append(plugin.provides, nestedPlugin.provides...)
+			}
+		}
+	}
+	if depth > 0 {
+		return nil
+	}
+	plugin.ID = metadata.ID
+	plugin.Name = metadata.Name
+	if plugin.Name == "" {
+		plugin.Name = metadata.ID
+	}
+	plugin.Version = metadata.Version
+	plugin.Description = metadata.Description
+	for _, author := range metadata.Authors {
+		var name string
+		var person struct {
+			Name string `json:"name"`
+		}
+		if json.Unmarshal(author, &name) == nil {
+			plugin.Authors = append(plugin.Authors, name)
+		} else if json.Unmarshal(author, &person) == nil && person.Name != "" {
+			plugin.Authors = append(plugin.Authors, person.Name)
+		}
+	}
+	for id := range metadata.Depends {
+		plugin.Dependencies = append(plugin.Dependencies, id)
+	}
+	for id := range metadata.Recommends {
+		plugin.SoftDependencies = append(plugin.SoftDependencies, id)
+	}
+	for id := range metadata.Suggests {
+		plugin.SoftDependencies = append(plugin.SoftDependencies, id)
+	}
+	slices.Sort(plugin.Dependencies)
+	slices.Sort(plugin.SoftDependencies)
+	return nil
+}
+
+// listPlugins lists the plugin and mod jars installed on a server, including disabled ones, and
+// checks the dependencies of enabled plugins against the other enabled plugins.
+func listPlugins(directory string) ([]*PluginInfo, error) {
+	plugins := make([]*PluginInfo, 0)
+	for _, folder := range pluginFolders {
+		for _, enabled := range []bool{true, false} {
+			dir := filepath.Join(directory, folder)
+			if !enabled {
+				dir = filepath.Join(dir, disabledPluginFolder)
+			}
+			entries, err := os.ReadDir(dir)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				if entry.IsDir() || !strings.HasSuffix(strings.ToLower(entry.Name()), ".jar") {
+					continue
+				}
+				plugin := &PluginInfo{
+					File:                entry.Name(),
+					Folder:              folder,
+					Enabled:             enabled,
+					Authors:             make([]string, 0),
+					Dependencies:        make([]string, 0),
+					SoftDependencies:    make([]string, 0),
+					MissingDependencies: make([]string, 0),
+				}
+				if info, err := entry.Info(); err == nil {
+					plugin.Size = info.Size()
+				}
+				if archive, err := zip.OpenReader(filepath.Join(dir, entry.Name())); err != nil {
+					plugin.Error = err.Error()
+				} else {
+					if err := readPluginMetadata(plugin, &archive.Reader); err != nil {
+						plugin.Error = err.Error()
+					}
+					archive.Close()
+				}
+				plugins = append(plugins, plugin)
+			}
+		}
+	}
+	// Check for missing dependencies.
+	available := make(map[string]bool)
+	for _, dependency := range builtinDependencies {
+		available[dependency] = true
+	}
+	for _, plugin := range plugins {
+		if plugin.Enabled {
+			for _, id := range plugin.provides {
+				available[id] = true
+			}
+		}
+	}
+	for _, plugin := range plugins {
+		for _, dependency := range plugin.Dependencies {
+			if !available[dependency] {
+				plugin.MissingDependencies = append(plugin.MissingDependencies, dependency)
+			}
+		}
+	}
+	return plugins, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+var errCommandNotAllowed = errors.New("you are not allowed to send this command")
+
+// compiledPatterns caches regular expressions from the config. Invalid patterns are stored as nil,
+// so their error is only logged once.
+var compiledPatterns = xsync.NewMapOf[string, *regexp.Regexp]()
+
+// compilePattern returns the compiled pattern, or nil if the pattern is invalid.
+func compilePattern(pattern string) *regexp.Regexp {
+	regex, _ := compiledPatterns.LoadOrCompute(pattern, func() *regexp.Regexp {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Println("Invalid pattern "+pattern+" in config!", err)
+		}
+		return regex
+	})
+	return regex
+}
+
+// matchesAnyPattern checks whether text matches any of the patterns. Invalid patterns never match.
+func matchesAnyPattern(text string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if regex := compilePattern(pattern); regex != nil && regex.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCommandPolicies checks whether a user may send a command to the console of a server. A
+// command is allowed if every policy applying to the user and server allows it. Policies for all
+// users (*) don't apply to @local. Commands containing multiple lines are only allowed if every
+// line is allowed, since each line is run separately. If the command isn't allowed, the name of
+// the policy which rejected it is returned.
+func checkCommandPolicies(policies map[string]CommandPolicyConfig, user string, server string, command string) (bool, string) {
+	lines := strings.FieldsFunc(command, func(r rune) bool { return r == '\r' || r == '\n' })
+	if len(lines) == 0 {
+		lines = []string{command}
+	}
+	for name, policy := range policies {
+		if !slices.Contains(policy.Users, user) && (user == "@local" || !slices.Contains(policy.Users, "*")) {
+			continue
+		} else if len(policy.Servers) > 0 && !slices.Contains(policy.Servers, server) {
+			continue
+		}
+		for _, line := range lines {
+			// Commands are matched without a leading slash, since servers usually accept both.
+			line = strings.TrimPrefix(strings.TrimSpace(line), "/")
+			if matchesAnyPattern(line, policy.Deny) ||
+				(len(policy.Allow) > 0 && !matchesAnyPattern(line, policy.Allow)) {
+				return false, name
+			}
+		}
+	}
+	return true, ""
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestCheckCommandPolicies(t *testing.T) {
+	policies := map[string]CommandPolicyConfig{
+		"helpers":  {Users: []string{"helper"}, Allow: []string{"^(say|list)( |$)"}},
+		"everyone": {Users: []string{"*"}, Deny: []string{"^stop$"}},
+	}
+	tests := []struct {
+		user    string
+		command string
+		allowed bool
+	}{
+		{"helper", "say hi", true},
+		{"helper", "/list", true},
+		{"helper", "op me", false},
+		{"helper", "say hi\nop me", false},
+		{"helper", "say hi\r\n/op me", false},
+		{"helper", "say hi\rop me", false},
+		{"helper", "say hi\n", true},
+		{"admin", "op me", true},
+		{"admin", "say hi\nstop", false},
+		{"@local", "stop", true},
+	}
+	for _, test := range tests {
+		if allowed, _ := checkCommandPolicies(policies, test.user, "s1", test.command); allowed != test.allowed {
+			t.Errorf("checkCommandPolicies(%q, %q) = %v; want %v", test.user, test.command, allowed, test.allowed)
+		}
+	}
+}
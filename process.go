@@ -22,15 +22,30 @@ type Process struct {
 	Name         string
 	CommandMutex sync.RWMutex
 	Command      *exec.Cmd
-	Online       atomic.Int32   // 0 for offline, 1 for online, 2 for failure
-	Output       *io.PipeReader // Never change, don't need synchronisation.
-	Input        *io.PipeWriter // Never change, don't need synchronisation.
-	Stdin        io.WriteCloser // Synchronised by CommandMutex.
+	Online       atomic.Int32    // 0 for offline, 1 for online, 2 for failure
+	Output       *io.PipeReader  // Never change, don't need synchronisation.
+	Input        *io.PipeWriter  // Never change, don't need synchronisation.
+	Stdin        *os.File        // Synchronised by CommandMutex, written to by writeInput.
+	InputQueue   chan stdinWrite // Never changes, input waiting to be written to stdin.
+	ControlQueue chan stdinWrite // Never changes, control commands written before InputQueue.
+	InputClosed  chan struct{}   // Never changes, closed when the process is removed.
 	Crashes      atomic.Int32
 	Uptime       atomic.Int64
 	ToDelete     atomic.Bool
+	// StateChanged is called when the server starts, stops or crashes. It is set by
+	// Connector.AddProcess before the server is first started, and never changes afterwards.
+	StateChanged func(state string)
 }
 
+// States of a server passed to Process.StateChanged.
+const (
+	stateStarting = "starting"
+	stateOnline   = "online"
+	stateStopping = "stopping"
+	stateStopped  = "stopped"
+	stateCrashed  = "crashed"
+)
+
 // CreateProcess creates and runs a process.
 func CreateProcess(name string, config ServerConfig, connector *Connector) *Process {
 	// Create the process.
@@ -41,14 +56,19 @@ func CreateProcess(name string, config ServerConfig, connector *Connector) *Proc
 		ServerConfig: config,
 		Output:       output,
 		Input:        input,
+		InputQueue:   make(chan stdinWrite, inputQueueSize),
+		ControlQueue: make(chan stdinWrite, controlQueueSize),
+		InputClosed:  make(chan struct{}),
 		//Crashes:      0,
 		//Uptime:       0,
 	}
+	go process.writeInput()
+	go removeStaleUploads(config.Directory)
+	connector.AddProcess(process)
 	// Run the command.
 	if config.Enabled {
 		process.StartProcess(connector) // Error is handled by StartProcess: skipcq GSC-G104
 	}
-	connector.AddProcess(process)
 	return process
 }
 
@@ -56,6 +76,7 @@ func CreateProcess(name string, config ServerConfig, connector *Connector) *Proc
 func (process *Process) StartProcess(connector *Connector) error {
 	name := process.Name
 	info.Println("Starting process (" + name + ")")
+	process.notifyStateChanged(stateStarting)
 	process.ServerConfigMutex.RLock()
 	defer process.ServerConfigMutex.RUnlock()
 	// Determine the command which should be run by Go and change the working directory.
@@ -65,14 +86,26 @@ func (process *Process) StartProcess(connector *Connector) error {
 	// Run the command after retrieving the standard out, standard in and standard err.
 	process.CommandMutex.Lock()
 	defer process.CommandMutex.Unlock()
-	process.Stdin, _ = command.StdinPipe()
+	// Unlike command.StdinPipe, os.Pipe supports write deadlines.
+	stdin, stdinWriter, err := os.Pipe()
+	if err != nil {
+		log.Println("Failed to start server " + name + "! The following error occured: " + err.Error())
+		process.Online.Store(2)
+		process.notifyStateChanged(stateCrashed)
+		return err
+	}
+	process.Stdin = stdinWriter
+	command.Stdin = stdin
 	command.Stdout = process.Input
 	command.Stderr = command.Stdout // We want the stderr and stdout to go to the same pipe.
-	err := command.Start()
+	err = command.Start()
+	stdin.Close() // The process has its own copy of the read end.
 	// Check for errors.
 	process.Online.Store(2)
 	if err != nil {
 		log.Println("Failed to start server " + name + "! The following error occured: " + err.Error())
+		stdinWriter.Close()
+		process.notifyStateChanged(stateCrashed)
 	} else if _, err := os.FindProcess(command.Process.Pid); err != nil /* Windows */ ||
 		// command.Process.Signal(syscall.Signal(0)) != nil /* Unix, disabled */ ||
 		command.ProcessState != nil /* Universal */ {
@@ -81,11 +114,13 @@ func (process *Process) StartProcess(connector *Connector) error {
 		var stdout bytes.Buffer
 		stdout.ReadFrom(process.Output)
 		log.Println("Output:\n" + stdout.String())
+		process.notifyStateChanged(stateCrashed)
 	} else {
 		info.Println("Started server " + name + " with PID " + strconv.Itoa(command.Process.Pid))
 		process.SendConsoleOutput("[Octyne] Started server " + name)
 		process.Online.Store(1)
 		process.Uptime.Store(time.Now().UnixNano())
+		process.notifyStateChanged(stateOnline)
 	}
 	// Update and return.
 	process.Command = command
@@ -93,22 +128,55 @@ func (process *Process) StartProcess(connector *Connector) error {
 	return err
 }
 
-// StopProcess stops the process with SIGTERM.
+// StopProcess stops the process by sending its stop commands, or with SIGTERM if it has none.
+// If the process is still running after its stop timeout, it is stopped with SIGTERM.
 func (process *Process) StopProcess() {
 	info.Println("Stopping server " + process.Name)
 	process.SendConsoleOutput("[Octyne] Stopping server " + process.Name)
+	process.notifyStateChanged(stateStopping)
+	process.ServerConfigMutex.RLock()
+	stopCommands := process.StopCommands
+	stopTimeout := parseStopTimeout(process.StopTimeout)
+	process.ServerConfigMutex.RUnlock()
 	process.CommandMutex.RLock()
 	defer process.CommandMutex.RUnlock()
 	command := process.Command
 	// SIGTERM works with: Java, Node, npm, yarn v1, yarn v2, PaperMC, Velocity, BungeeCord, Waterfall
 	// SIGINT fails with yarn v1 and v2, hence is not used.
-	command.Process.Signal(syscall.SIGTERM)
+	if len(stopCommands) == 0 {
+		command.Process.Signal(syscall.SIGTERM)
+		return
+	}
+	for _, stopCommand := range stopCommands {
+		if _, err := process.QueueControlInput(stopCommand); err != nil {
+			log.Println("Failed to send stop command to server "+process.Name+"!", err)
+		}
+	}
+	go (func() {
+		<-time.After(stopTimeout)
+		process.CommandMutex.RLock()
+		defer process.CommandMutex.RUnlock()
+		if process.Command == command && process.Online.Load() == 1 {
+			info.Println("Server " + process.Name + " did not stop in time, stopping it with SIGTERM")
+			command.Process.Signal(syscall.SIGTERM)
+		}
+	})()
+}
+
+// parseStopTimeout parses the stop timeout of a server, which defaults to 30 seconds.
+func parseStopTimeout(stopTimeout string) time.Duration {
+	duration, err := time.ParseDuration(stopTimeout)
+	if err != nil {
+		return 30 * time.Second
+	}
+	return duration
 }
 
 // KillProcess stops the process.
 func (process *Process) KillProcess() {
 	info.Println("Killing server " + process.Name)
 	process.SendConsoleOutput("[Octyne] Killing server " + process.Name)
+	process.notifyStateChanged(stateStopping)
 	process.CommandMutex.RLock()
 	defer process.CommandMutex.RUnlock()
 	command := process.Command
@@ -116,11 +184,32 @@ func (process *Process) KillProcess() {
 	process.Online.Store(0)
 }
 
-// SendCommand sends an input to stdin of the process.
-func (process *Process) SendCommand(command string) {
-	process.CommandMutex.RLock()
-	defer process.CommandMutex.RUnlock()
-	fmt.Fprintln(process.Stdin, command)
+// RestartProcess stops the process, waits for it to stop, then starts it again. If the process
+// doesn't stop within its stop timeout and a grace period, it isn't started again.
+func (process *Process) RestartProcess(connector *Connector) {
+	process.ServerConfigMutex.RLock()
+	stopTimeout := parseStopTimeout(process.StopTimeout)
+	process.ServerConfigMutex.RUnlock()
+	process.StopProcess()
+	deadline := time.Now().Add(stopTimeout + 10*time.Second)
+	for process.Online.Load() == 1 {
+		if time.Now().After(deadline) {
+			log.Println("Server " + process.Name + " did not stop in time, and will not be restarted!")
+			return
+		}
+		<-time.After(100 * time.Millisecond)
+	}
+	// If the server crashed while stopping, it is already restarted by MonitorProcess.
+	if process.Online.Load() == 0 {
+		process.StartProcess(connector) // Error is handled by StartProcess: skipcq GSC-G104
+	}
+}
+
+// notifyStateChanged calls StateChanged if it is set.
+func (process *Process) notifyStateChanged(state string) {
+	if process.StateChanged != nil {
+		process.StateChanged(state)
+	}
 }
 
 // SendConsoleOutput sends console output to the stdout of the process.
@@ -143,18 +232,13 @@ func (process *Process) MonitorProcess(connector *Connector) error {
 	}
 	// Wait for the command to finish execution.
 	err := process.Command.Wait()
+	process.Stdin.Close() // Input sent from now on fails instead of waiting for a timeout.
 	// Mark as offline appropriately.
 	if process.ToDelete.Load() {
 		process.SendConsoleOutput("[Octyne] Server " + process.Name + " was marked for deletion, " +
 			"stopped/crashed, and has now been removed.")
-		if process, loaded := connector.Processes.LoadAndDelete(process.Name); loaded {
-			<-time.After(5 * time.Second)
-			process.Clients.Range(func(connection chan interface{}, _ string) bool {
-				connection <- nil
-				return true
-			})
-			process.Clients.Clear()
-		}
+		process.notifyStateChanged(stateStopped)
+		connector.RemoveProcess(process.Name)
 	} else if process.Command.ProcessState.Success() ||
 		process.Online.Load() == 0 /* SIGKILL (if done by Octyne) */ ||
 		process.Command.ProcessState.ExitCode() == 130 /* SIGINT */ ||
@@ -164,11 +248,13 @@ func (process *Process) MonitorProcess(connector *Connector) error {
 		process.Crashes.Store(0)
 		info.Println("Server " + process.Name + " has stopped.")
 		process.SendConsoleOutput("[Octyne] Server " + process.Name + " has stopped.")
+		process.notifyStateChanged(stateStopped)
 	} else {
 		process.Online.Store(2)
 		process.Uptime.Store(0)
 		crashes := process.Crashes.Add(1)
 		process.SendConsoleOutput("[Octyne] Server " + process.Name + " has crashed!")
+		process.notifyStateChanged(stateCrashed)
 		info.Println("Server " + process.Name + " has crashed!")
 		if crashes <= 3 {
 			process.SendConsoleOutput("[Octyne] Restarting server " + process.Name + " due to default behaviour.")
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// propertiesLine is a logical line of a .properties file, which is either a comment, a blank line,
+// or a property. Properties may span multiple lines using line continuations.
+type propertiesLine struct {
+	raw      string // The text of the line as it is in the file, without a trailing newline.
+	property bool
+	key      string
+	value    string
+	prefix   string // The key and separator as they are in the file, kept when the value changes.
+}
+
+// PropertiesFile is a Java .properties file, which can be edited while preserving its comments,
+// formatting and the order of its properties.
+type PropertiesFile struct {
+	lines           []*propertiesLine
+	newline         string
+	trailingNewline bool
+}
+
+// ParseProperties parses the contents of a .properties file.
+func ParseProperties(data string) *PropertiesFile {
+	file := &PropertiesFile{newline: "\n"}
+	if strings.Contains(data, "\r\n") {
+		file.newline = "\r\n"
+	}
+	if data == "" {
+		file.trailingNewline = true
+		return file
+	}
+	physicalLines := strings.Split(data, "\n")
+	if physicalLines[len(physicalLines)-1] == "" {
+		file.trailingNewline = true
+		physicalLines = physicalLines[:len(physicalLines)-1]
+	}
+	for i := 0; i < len(physicalLines); i++ {
+		line := strings.TrimSuffix(physicalLines[i], "\r")
+		trimmed := strings.TrimLeft(line, " \t\f")
+		if trimmed == "" || trimmed[0] == '#' || trimmed[0] == '!' {
+			file.lines = append(file.lines, &propertiesLine{raw: line})
+			continue
+		}
+		// Join lines ending with an odd number of backslashes with the line after them.
+		raw := line
+		logical := trimmed
+		for hasLineContinuation(logical) && i+1 < len(physicalLines) {
+			i++
+			next := strings.TrimSuffix(physicalLines[i], "\r")
+			raw += file.newline + next
+			logical = logical[:len(logical)-1] + strings.TrimLeft(next, " \t\f")
+		}
+		if hasLineContinuation(logical) {
+			logical = logical[:len(logical)-1]
+		}
+		key, value, valueStart := parseProperty(logical)
+		file.lines = append(file.lines, &propertiesLine{
+			raw:      raw,
+			property: true,
+			key:      key,
+			value:    value,
+			prefix:   line[:len(line)-len(trimmed)] + logical[:valueStart],
+		})
+	}
+	return file
+}
+
+// hasLineContinuation checks whether a line ends with an odd number of backslashes.
+func hasLineContinuation(line string) bool {
+	backslashes := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+// parseProperty parses a logical line into its key and value, and returns the index the value
+// starts at. The key ends at the first unescaped =, : or whitespace.
+func parseProperty(line string) (string, string, int) {
+	keyEnd := 0
+	for keyEnd < len(line) {
+		if line[keyEnd] == '\\' {
+			keyEnd += 2
+			continue
+		} else if strings.IndexByte("=: \t\f", line[keyEnd]) != -1 {
+			break
+		}
+		keyEnd++
+	}
+	keyEnd = min(keyEnd, len(line))
+	valueStart := keyEnd
+	for valueStart < len(line) && strings.IndexByte(" \t\f", line[valueStart]) != -1 {
+		valueStart++
+	}
+	if valueStart < len(line) && (line[valueStart] == '=' || line[valueStart] == ':') {
+		valueStart++
+		for valueStart < len(line) && strings.IndexByte(" \t\f", line[valueStart]) != -1 {
+			valueStart++
+		}
+	}
+	return unescapeProperty(line[:keyEnd]), unescapeProperty(line[valueStart:]), valueStart
+}
+
+// unescapeProperty replaces the escape sequences in a key or value with the characters they
+// represent.
+func unescapeProperty(text string) string {
+	if !strings.Contains(text, "\\") {
+		return text
+	}
+	var builder strings.Builder
+	var surrogate rune // A high surrogate waiting for the low surrogate after it.
+	for i := 0; i < len(text); i++ {
+		if surrogate != 0 && !isLowSurrogateEscape(text[i:]) {
+			builder.WriteRune(utf8.RuneError) // Lone high surrogates are replaced, like lone low ones.
+			surrogate = 0
+		}
+		if text[i] != '\\' || i+1 >= len(text) {
+			builder.WriteByte(text[i])
+			continue
+		}
+		i++
+		switch text[i] {
+		case 't':
+			builder.WriteByte('\t')
+		case 'n':
+			builder.WriteByte('\n')
+		case 'r':
+			builder.WriteByte('\r')
+		case 'f':
+			builder.WriteByte('\f')
+		case 'u':
+			code, err := strconv.ParseUint(text[i+1:min(i+5, len(text))], 16, 16)
+			if err != nil || i+5 > len(text) {
+				builder.WriteByte('u')
+				continue
+			}
+			i += 4
+			char := rune(code)
+			if surrogate != 0 {
+				builder.WriteRune(utf16.DecodeRune(surrogate, char))
+				surrogate = 0
+			} else if char >= 0xd800 && char < 0xdc00 {
+				surrogate = char
+			} else {
+				builder.WriteRune(char)
+			}
+		default:
+			builder.WriteByte(text[i])
+		}
+	}
+	if surrogate != 0 {
+		builder.WriteRune(utf8.RuneError)
+	}
+	return builder.String()
+}
+
+// isLowSurrogateEscape checks whether text starts with a \u escape of a low surrogate.
+func isLowSurrogateEscape(text string) bool {
+	if len(text) < 6 || text[:2] != "\\u" {
+		return false
+	}
+	code, err := strconv.ParseUint(text[2:6], 16, 16)
+	return err == nil && code >= 0xdc00 && code <= 0xdfff
+}
+
+// escapeProperty escapes a key or value so it can be written to a .properties file. Characters
+// outside of ASCII are escaped, since Java reads .properties files as ISO 8859-1 by default.
+func escapeProperty(text string, key bool) string {
+	var builder strings.Builder
+	for i, char := range text {
+		switch {
+		case char == '\\':
+			builder.WriteString("\\\\")
+		case char == '\t':
+			builder.WriteString("\\t")
+		case char == '\n':
+			builder.WriteString("\\n")
+		case char == '\r':
+			builder.WriteString("\\r")
+		case char == '\f':
+			builder.WriteString("\\f")
+		case char == ' ' && (key || i == 0):
+			builder.WriteString("\\ ")
+		case key && (char == '=' || char == ':' || char == '#' || char == '!'):
+			builder.WriteByte('\\')
+			builder.WriteRune(char)
+		case char < 0x20 || char > 0x7e:
+			for _, unit := range utf16.Encode([]rune{char}) {
+				builder.WriteString("\\u" + strings.ToUpper(strconv.FormatUint(uint64(unit)+0x10000, 16)[1:]))
+			}
+		default:
+			builder.WriteRune(char)
+		}
+	}
+	return builder.String()
+}
+
+// Map returns the properties in the file. If a key is present multiple times, the last value is
+// used, like in Java.
+func (p *PropertiesFile) Map() map[string]string {
+	properties := make(map[string]string)
+	for _, line := range p.lines {
+		if line.property {
+			properties[line.key] = line.value
+		}
+	}
+	return properties
+}
+
+// Set sets the value of a property, keeping its position in the file. New properties are added to
+// the end of the file.
+func (p *PropertiesFile) Set(key string, value string) {
+	found := false
+	for _, line := range p.lines {
+		if line.property && line.key == key {
+			line.value = value
+			line.raw = line.prefix + escapeProperty(value, false)
+			found = true
+		}
+	}
+	if !found {
+		prefix := escapeProperty(key, true) + "="
+		p.lines = append(p.lines, &propertiesLine{
+			raw:      prefix + escapeProperty(value, false),
+			property: true,
+			key:      key,
+			value:    value,
+			prefix:   prefix,
+		})
+	}
+}
+
+// Delete removes a property from the file.
+func (p *PropertiesFile) Delete(key string) {
+	lines := p.lines[:0]
+	for _, line := range p.lines {
+		if !line.property || line.key != key {
+			lines = append(lines, line)
+		}
+	}
+	p.lines = lines
+}
+
+// String returns the contents of the file.
+func (p *PropertiesFile) String() string {
+	var builder strings.Builder
+	for i, line := range p.lines {
+		if i > 0 {
+			builder.WriteString(p.newline)
+		}
+		builder.WriteString(line.raw)
+	}
+	if p.trailingNewline && len(p.lines) > 0 {
+		builder.WriteString(p.newline)
+	}
+	return builder.String()
+}
@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestPropertiesRoundTrip(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"#Minecraft server properties\n#Sat Jan 01 00:00:00 UTC 2026\nmotd=A Minecraft Server\nserver-port=25565\n",
+		"! comment\r\n\r\n  key : value \r\nother\tvalue\r\n",
+		"no-trailing-newline=true",
+		"long = first, \\\n    second, \\\n    third\nkey\\=with\\:separators\\ =value\n",
+		"motd=\\u00A7aHello \\u4E16\\u754C \\uD83D\\uDE00\nempty=\nkey-only\n",
+	} {
+		if result := ParseProperties(data).String(); result != data {
+			t.Errorf("expected %q to be unchanged, got %q", data, result)
+		}
+	}
+}
+
+func TestPropertiesParse(t *testing.T) {
+	properties := ParseProperties("a=1\n" +
+		"  b : two words \n" +
+		"c\\=d\\:e\\ f=3\n" +
+		"g\\\\=4\n" +
+		"long = first, \\\n    second, \\\\\n" +
+		"h\n" +
+		"motd=\\u00A7a\\u4E16\\uD83D\\uDE00\\n\\t\\\\\n" +
+		"a=last\n" +
+		"trailing=\\").Map()
+	for key, value := range map[string]string{
+		"a":        "last",
+		"b":        "two words ",
+		"c=d:e f":  "3",
+		"g\\":      "4",
+		"long":     "first, second, \\",
+		"h":        "",
+		"trailing": "",
+		"motd":     "§a世😀\n\t\\",
+	} {
+		if properties[key] != value {
+			t.Errorf("expected %q to be %q, got %q", key, value, properties[key])
+		}
+	}
+	if len(properties) != 8 {
+		t.Errorf("expected 8 properties, got %d: %q", len(properties), properties)
+	}
+}
+
+func TestPropertiesSet(t *testing.T) {
+	properties := ParseProperties("# comment\r\nlong = first, \\\r\n    second\r\nkey\\=a = b\r\nother=1\r\n")
+	properties.Set("long", "edited")
+	properties.Set("key=a", "c")
+	properties.Set("new key:", " ünicode 😀\n")
+	expected := "# comment\r\nlong = edited\r\nkey\\=a = c\r\nother=1\r\n" +
+		"new\\ key\\:=\\ \\u00FCnicode \\uD83D\\uDE00\\n\r\n"
+	if result := properties.String(); result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+	reparsed := ParseProperties(properties.String()).Map()
+	if reparsed["long"] != "edited" || reparsed["key=a"] != "c" || reparsed["new key:"] != " ünicode 😀\n" {
+		t.Errorf("unexpected properties after editing: %q", reparsed)
+	}
+	properties.Delete("long")
+	if _, ok := properties.Map()["long"]; ok {
+		t.Error("expected long to be deleted")
+	}
+}
+
+func TestUnescapePropertySurrogates(t *testing.T) {
+	for escaped, expected := range map[string]string{
+		"\\uD83D\\uDE00":  "😀",
+		"\\uD83Dx":        "�x",
+		"\\uD83D":         "�",
+		"\\uD83D\\u0041":  "�A",
+		"\\uD83D\\uD83D!": "��!",
+		"\\uD83D\\n":      "�\n",
+		"\\uDE00":         "�",
+		"\\uD83D\\uZZZZ":  "�uZZZZ",
+	} {
+		if result := unescapeProperty(escaped); result != expected {
+			t.Errorf("expected %q to be unescaped to %q, got %q", escaped, expected, result)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/retrixe/octyne/rcon"
+)
+
+var errRCONDisabled = errors.New("rcon is not enabled for this server")
+
+// rconTimeout is the timeout for connecting to servers over RCON and for each command.
+const rconTimeout = 10 * time.Second
+
+// rconConnection is a connection to a server over RCON. It is opened when first used, and reopened
+// if it fails or the RCON config of the server changes.
+type rconConnection struct {
+	mutex    sync.Mutex
+	client   *rcon.Client
+	address  string
+	password string
+}
+
+// Execute sends a command over the connection and returns its response.
+func (r *rconConnection) Execute(config RCONConfig, command string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	host := config.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(int(config.Port)))
+	if r.client != nil && (r.address != address || r.password != config.Password) {
+		r.client.Close()
+		r.client = nil
+	}
+	// If an existing connection was closed, e.g. because the server restarted, it is reopened once.
+	// Commands are only sent again if they weren't sent at all, so they are never run twice.
+	reused := r.client != nil
+	for {
+		if r.client == nil {
+			client, err := rcon.Dial(address, config.Password, rconTimeout)
+			if err != nil {
+				return "", err
+			}
+			r.client, r.address, r.password = client, address, config.Password
+		}
+		response, err := r.client.Execute(command)
+		if err == nil || errors.Is(err, rcon.ErrCommandTooLong) {
+			return response, err
+		}
+		r.client.Close()
+		r.client = nil
+		if !reused || !errors.Is(err, rcon.ErrNotSent) {
+			return "", err
+		}
+		reused = false
+	}
+}
+
+// Close closes the connection if it is open.
+func (r *rconConnection) Close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.client != nil {
+		r.client.Close()
+		r.client = nil
+	}
+}
+
+// ExecuteRCON sends a command to the server over RCON and returns its response.
+func (process *ExposedProcess) ExecuteRCON(command string) (string, error) {
+	process.ServerConfigMutex.RLock()
+	config := process.RCON
+	process.ServerConfigMutex.RUnlock()
+	if config == nil {
+		return "", errRCONDisabled
+	}
+	return process.RCONConnection.Execute(*config, command)
+}
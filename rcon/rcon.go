@@ -0,0 +1,181 @@
+// Package rcon implements a client for the Source RCON protocol, which is also used by Minecraft.
+package rcon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrAuthFailed is returned when the server rejects the RCON password.
+var ErrAuthFailed = errors.New("rcon authentication failed")
+
+// ErrCommandTooLong is returned when a command is too long to be sent to the server.
+var ErrCommandTooLong = errors.New("rcon command is too long")
+
+// ErrNotSent is returned when a command couldn't be sent because the connection is closed or
+// broken, e.g. because the server restarted, so the command can safely be sent again.
+var ErrNotSent = errors.New("rcon command was not sent")
+
+var errInvalidPacket = errors.New("invalid rcon packet")
+
+// Packet types of the Source RCON protocol. Execute and auth response share the same value.
+const (
+	packetResponse     int32 = 0
+	packetExecute      int32 = 2
+	packetAuthResponse int32 = 2
+	packetAuth         int32 = 3
+)
+
+const (
+	// maxCommandLength is the maximum length of a command accepted by Minecraft servers.
+	maxCommandLength = 1446
+	// maxPacketSize is the maximum size of a packet accepted from the server.
+	maxPacketSize = 1 << 20
+)
+
+// Client is a connection to an RCON server. It is safe for concurrent use.
+type Client struct {
+	mutex   sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	nextID  int32
+	timeout time.Duration
+}
+
+// Dial connects to an RCON server at address and authenticates with password. The timeout is
+// used for connecting and for each command.
+func Dial(address string, password string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}
+	err = client.authenticate(password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (c *Client) authenticate(password string) error {
+	c.conn.SetDeadline(time.Now().Add(c.timeout)) // skipcq GSC-G104
+	id := c.newID()
+	err := c.writePacket(id, packetAuth, password)
+	if err != nil {
+		return err
+	}
+	// Source servers send an empty response before the auth response, which is skipped.
+	for {
+		responseID, packetType, _, err := c.readPacket()
+		if err != nil {
+			return err
+		} else if packetType != packetAuthResponse {
+			continue
+		} else if responseID == -1 || responseID != id {
+			return ErrAuthFailed
+		}
+		return nil
+	}
+}
+
+// Execute sends a command to the server and returns its response. If the command wasn't sent
+// because the connection is closed, an error wrapping ErrNotSent is returned. Otherwise, the
+// command may have been run by the server even if an error is returned.
+func (c *Client) Execute(command string) (string, error) {
+	if len(command) > maxCommandLength {
+		return "", ErrCommandTooLong
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// Writes to a connection closed by the server usually succeed, so check if the server closed
+	// the connection first. Peek doesn't consume any packets left over from earlier commands. Reads
+	// with a deadline which has already passed fail without checking the connection, so the check
+	// waits for up to a millisecond if the connection is open.
+	c.conn.SetReadDeadline(time.Now().Add(time.Millisecond)) // skipcq GSC-G104
+	if _, err := c.reader.Peek(1); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+		return "", fmt.Errorf("%w: %w", ErrNotSent, err)
+	}
+	c.conn.SetDeadline(time.Now().Add(c.timeout)) // skipcq GSC-G104
+	id := c.newID()
+	err := c.writePacket(id, packetExecute, command)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrNotSent, err)
+	}
+	// Long responses are split into multiple packets, so an empty response packet is sent after
+	// the command. Servers reply to it after the entire response, which marks its end. It's only
+	// sent once the response starts, since Minecraft servers expect a single packet per read.
+	var endID int32
+	var response bytes.Buffer
+	for {
+		responseID, _, body, err := c.readPacket()
+		if err != nil {
+			return "", err
+		} else if endID != 0 && responseID == endID {
+			return response.String(), nil
+		} else if responseID == id {
+			response.Write(body)
+			if endID == 0 {
+				endID = c.newID()
+				if err := c.writePacket(endID, packetResponse, ""); err != nil {
+					return "", err
+				}
+			}
+		}
+		// Packets with other IDs are left over from earlier commands, and are skipped.
+	}
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) newID() int32 {
+	c.nextID++
+	if c.nextID <= 0 {
+		c.nextID = 1
+	}
+	return c.nextID
+}
+
+// writePacket writes a packet, which consists of its size, ID, type, and null-terminated body
+// followed by an empty null-terminated string, all in little-endian.
+func (c *Client) writePacket(id int32, packetType int32, body string) error {
+	packet := make([]byte, 0, len(body)+14)
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(len(body)+10))
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(id))
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(packetType))
+	packet = append(packet, body...)
+	packet = append(packet, 0, 0)
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+// readPacket reads a packet and returns its ID, type and body.
+func (c *Client) readPacket() (int32, int32, []byte, error) {
+	var size int32
+	err := binary.Read(c.reader, binary.LittleEndian, &size)
+	if err != nil {
+		return 0, 0, nil, err
+	} else if size < 10 || size > maxPacketSize {
+		return 0, 0, nil, errInvalidPacket
+	}
+	packet := make([]byte, size)
+	_, err = io.ReadFull(c.reader, packet)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	id := int32(binary.LittleEndian.Uint32(packet[0:4]))
+	packetType := int32(binary.LittleEndian.Uint32(packet[4:8]))
+	body := packet[8 : len(packet)-2] // Without the null terminators.
+	return id, packetType, body, nil
+}
@@ -0,0 +1,120 @@
+package rcon
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTimeout = 2 * time.Second
+
+// writeTestPacket writes a packet in the same format as Client.writePacket.
+func writeTestPacket(w io.Writer, id int32, packetType int32, body string) error {
+	packet := binary.LittleEndian.AppendUint32(nil, uint32(len(body)+10))
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(id))
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(packetType))
+	packet = append(packet, body...)
+	_, err := w.Write(append(packet, 0, 0))
+	return err
+}
+
+// readTestPacket reads a packet in the same format as Client.readPacket.
+func readTestPacket(r io.Reader) (int32, int32, string, error) {
+	var size int32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return 0, 0, "", err
+	}
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return 0, 0, "", err
+	}
+	id := int32(binary.LittleEndian.Uint32(packet[0:4]))
+	packetType := int32(binary.LittleEndian.Uint32(packet[4:8]))
+	return id, packetType, string(packet[8 : len(packet)-2]), nil
+}
+
+// serveFakeRCON accepts RCON connections authenticating with password, and replies to each command
+// with the packets returned by respond, followed by a reply to the end of response packet.
+func serveFakeRCON(t *testing.T, password string, respond func(command string) []string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					id, packetType, body, err := readTestPacket(conn)
+					if err != nil {
+						return
+					}
+					switch packetType {
+					case packetAuth:
+						// Like Source servers, send an empty response before the auth response.
+						writeTestPacket(conn, id, packetResponse, "")
+						if body != password {
+							id = -1
+						}
+						writeTestPacket(conn, id, packetAuthResponse, "")
+					case packetExecute:
+						// Minecraft servers expect a single packet per read, so nothing may be sent
+						// before the response to the command.
+						conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+						if n, _ := conn.Read(make([]byte, 1)); n > 0 {
+							t.Error("a packet was sent before the response to the command")
+							return
+						}
+						conn.SetReadDeadline(time.Time{})
+						for _, response := range respond(body) {
+							writeTestPacket(conn, id, packetResponse, response)
+						}
+					case packetResponse:
+						writeTestPacket(conn, id, packetResponse, "Unknown request 0")
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestExecute(t *testing.T) {
+	address := serveFakeRCON(t, "secret", func(command string) []string {
+		if command == "help" { // Long responses are split into multiple packets.
+			return []string{strings.Repeat("a", 4096), strings.Repeat("b", 100)}
+		}
+		return []string{"ran " + command}
+	})
+	client, err := Dial(address, "secret", testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if response, err := client.Execute("list"); err != nil || response != "ran list" {
+		t.Errorf("unexpected response: %q, %v", response, err)
+	}
+	if response, err := client.Execute("help"); err != nil ||
+		response != strings.Repeat("a", 4096)+strings.Repeat("b", 100) {
+		t.Errorf("unexpected multi-packet response of length %d: %v", len(response), err)
+	}
+	if _, err := client.Execute(strings.Repeat("a", maxCommandLength+1)); !errors.Is(err, ErrCommandTooLong) {
+		t.Errorf("expected ErrCommandTooLong, got %v", err)
+	}
+}
+
+func TestDialAuthFailed(t *testing.T) {
+	address := serveFakeRCON(t, "secret", func(command string) []string { return nil })
+	if _, err := Dial(address, "wrong", testTimeout); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("expected ErrAuthFailed, got %v", err)
+	}
+}
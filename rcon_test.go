@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRCONServer accepts RCON connections and replies to each command with the command itself. The
+// stop command closes the connection without a response, like a server which stopped.
+type fakeRCONServer struct {
+	Port        uint16
+	Connections atomic.Int32
+	Commands    atomic.Int32
+	Closed      chan struct{} // Receives a value each time a connection is closed.
+}
+
+// serveFakeRCON starts a fakeRCONServer. If closeAfterResponse is true, each connection is closed
+// after the response to a command, like a server which restarted after each command.
+func serveFakeRCON(t *testing.T, closeAfterResponse bool) *fakeRCONServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server := &fakeRCONServer{
+		Port:   uint16(listener.Addr().(*net.TCPAddr).Port),
+		Closed: make(chan struct{}, 10),
+	}
+	write := func(w io.Writer, id uint32, packetType uint32, body string) {
+		packet := binary.LittleEndian.AppendUint32(nil, uint32(len(body)+10))
+		packet = binary.LittleEndian.AppendUint32(packet, id)
+		packet = binary.LittleEndian.AppendUint32(packet, packetType)
+		w.Write(append(append(packet, body...), 0, 0))
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.Connections.Add(1)
+			go func() {
+				defer func() { server.Closed <- struct{}{} }()
+				defer conn.Close()
+				for {
+					var size uint32
+					if binary.Read(conn, binary.LittleEndian, &size) != nil || size < 10 {
+						return
+					}
+					packet := make([]byte, size)
+					if _, err := io.ReadFull(conn, packet); err != nil {
+						return
+					}
+					id := binary.LittleEndian.Uint32(packet[0:4])
+					switch binary.LittleEndian.Uint32(packet[4:8]) {
+					case 3: // Auth, accepting any password.
+						write(conn, id, 2, "")
+					case 2: // Execute
+						server.Commands.Add(1)
+						command := string(packet[8 : len(packet)-2])
+						if command == "stop" {
+							return
+						}
+						write(conn, id, 0, command)
+					case 0: // End of response
+						write(conn, id, 0, "")
+						if closeAfterResponse {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return server
+}
+
+// waitForClose waits until the server closes a connection.
+func (server *fakeRCONServer) waitForClose(t *testing.T) {
+	select {
+	case <-server.Closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the connection to be closed")
+	}
+}
+
+func TestRCONConnectionReconnect(t *testing.T) {
+	server := serveFakeRCON(t, true)
+	config := RCONConfig{Port: server.Port, Password: "secret"}
+	var connection rconConnection
+	defer connection.Close()
+	for _, command := range []string{"list", "say hi"} {
+		if response, err := connection.Execute(config, command); err != nil || response != command {
+			t.Errorf("Execute(%q) = %q, %v", command, response, err)
+		}
+		server.waitForClose(t)
+	}
+	if n := server.Connections.Load(); n != 2 {
+		t.Errorf("expected 2 connections, got %d", n)
+	}
+	// A new connection is opened when the RCON config changes.
+	config.Password = "changed"
+	if _, err := connection.Execute(config, "list"); err != nil {
+		t.Error(err)
+	} else if n := server.Connections.Load(); n != 3 {
+		t.Errorf("expected 3 connections, got %d", n)
+	}
+}
+
+func TestRCONConnectionNoReplay(t *testing.T) {
+	server := serveFakeRCON(t, false)
+	config := RCONConfig{Port: server.Port, Password: "secret"}
+	var connection rconConnection
+	defer connection.Close()
+	if _, err := connection.Execute(config, "list"); err != nil {
+		t.Fatal(err)
+	}
+	// The connection is reused, and closed by the server after running the command, which must not
+	// be run again over a new connection.
+	if _, err := connection.Execute(config, "stop"); err == nil {
+		t.Error("expected an error when the connection is closed after running the command")
+	}
+	if n := server.Commands.Load(); n != 2 {
+		t.Errorf("expected each command to run once, but %d commands were run", n)
+	} else if n := server.Connections.Load(); n != 1 {
+		t.Errorf("expected 1 connection, got %d", n)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+// lastScheduledRuns stores when scheduled tasks were last attempted, so failing tasks are not
+// retried more often than their interval.
+var lastScheduledRuns = xsync.NewMapOf[string, time.Time]()
+
+// scheduledJobsRunning contains the names of servers whose scheduled backups and snapshots are
+// being run, so that long backups don't overlap with the jobs of the next check.
+var scheduledJobsRunning = xsync.NewMapOf[string, bool]()
+
+// isTaskDue checks whether a scheduled task should be run, given when it was last completed.
+func isTaskDue(key string, interval string, lastCompleted time.Time) bool {
+	duration, err := time.ParseDuration(interval)
+	if err != nil || duration <= 0 {
+		return false
+	}
+	if lastAttempt, ok := lastScheduledRuns.Load(key); ok && lastAttempt.After(lastCompleted) {
+		lastCompleted = lastAttempt
+	}
+	if time.Since(lastCompleted) < duration {
+		return false
+	}
+	lastScheduledRuns.Store(key, time.Now())
+	return true
+}
+
+// clearTaskAttempt forgets the last attempt of a scheduled task, so it is retried on the next
+// check, e.g. if it couldn't run because another backup of the server was in progress.
+func clearTaskAttempt(key string) {
+	lastScheduledRuns.Delete(key)
+}
+
+// RunScheduler checks every minute whether any scheduled tasks of any server are due and runs them.
+func (connector *Connector) RunScheduler() {
+	for {
+		<-time.After(time.Minute)
+		connector.Processes.Range(func(name string, process *ExposedProcess) bool {
+			if !process.ToDelete.Load() {
+				go process.runScheduledJobs()
+				go process.rotateConsoleLog(connector.Config.Load().Logging.Console.Rotate)
+			}
+			return true
+		})
+	}
+}
+
+// runScheduledJobs runs the scheduled backups and snapshots of the server one after another, since
+// only one backup or snapshot of a server can be created at once.
+func (process *Process) runScheduledJobs() {
+	if _, loaded := scheduledJobsRunning.LoadOrStore(process.Name, true); loaded {
+		return
+	}
+	defer scheduledJobsRunning.Delete(process.Name)
+	process.runScheduledBackups()
+	process.runScheduledSnapshots()
+}
+
+func (process *Process) runScheduledBackups() {
+	process.ServerConfigMutex.RLock()
+	backups := process.Backups
+	process.ServerConfigMutex.RUnlock()
+	for name, config := range backups {
+		if config.Interval == "" {
+			continue
+		}
+		_, dir, err := process.getBackupConfig(name)
+		if err != nil {
+			log.Println("Invalid backup "+name+" for server "+process.Name+"!", err)
+			continue
+		}
+		existing, err := listBackups(dir)
+		if err != nil {
+			log.Println("An error occurred when listing backups of server "+process.Name+"!", err)
+			continue
+		}
+		var lastBackup time.Time
+		if len(existing) > 0 {
+			lastBackup = time.Unix(existing[0].Time, 0)
+		}
+		key := "backup:" + process.Name + ":" + name
+		if !isTaskDue(key, config.Interval, lastBackup) {
+			continue
+		}
+		info.Println("Running scheduled backup " + name + " of server " + process.Name)
+		if _, err := process.CreateBackup(name); errors.Is(err, errBackupInProgress) {
+			info.Println("Postponed scheduled backup " + name + " of server " + process.Name +
+				", since another backup is in progress")
+			clearTaskAttempt(key)
+		} else if err != nil {
+			log.Println("An error occurred when running scheduled backup "+name+
+				" of server "+process.Name+"!", err)
+		}
+	}
+}
+
+func (process *Process) runScheduledSnapshots() {
+	config, store, err := process.getSnapshotStore()
+	if errors.Is(err, errSnapshotsDisabled) || (err == nil && config.Interval == "") {
+		return
+	} else if err != nil {
+		log.Println("Invalid snapshot config for server "+process.Name+"!", err)
+		return
+	}
+	existing, err := store.List(process.Name)
+	if err != nil {
+		log.Println("An error occurred when listing snapshots of server "+process.Name+"!", err)
+		return
+	}
+	var lastSnapshot time.Time
+	if len(existing) > 0 {
+		lastSnapshot = time.Unix(existing[0].Time, 0)
+	}
+	key := "snapshot:" + process.Name
+	if !isTaskDue(key, config.Interval, lastSnapshot) {
+		return
+	}
+	info.Println("Running scheduled snapshot of server " + process.Name)
+	if _, err := process.CreateSnapshot(); errors.Is(err, errBackupInProgress) {
+		info.Println("Postponed scheduled snapshot of server " + process.Name + ", since a backup is in progress")
+		clearTaskAttempt(key)
+	} else if err != nil {
+		log.Println("An error occurred when running scheduled snapshot of server "+process.Name+"!", err)
+	}
+}
+
+func (process *ExposedProcess) rotateConsoleLog(interval string) {
+	if interval == "" || !isTaskDue("consoleLog:"+process.Name, interval, process.ConsoleLog.LastRotated()) {
+		return
+	}
+	if err := process.ConsoleLog.Rotate(); err != nil {
+		log.Println("An error occurred when rotating the console log of server "+process.Name+"!", err)
+	}
+}
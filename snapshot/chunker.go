@@ -0,0 +1,65 @@
+package snapshot
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// Chunks are split using content-defined chunking with a gear hash, so that inserting or removing
+// data in a file only changes the chunks surrounding the modification.
+const (
+	minChunkSize  = 64 << 10
+	maxChunkSize  = 1 << 20
+	chunkHashMask = 1<<18 - 1 // Results in an average chunk size of ~256 KiB above the minimum.
+)
+
+var gearTable [256]uint64
+
+func init() {
+	// The table must never change, else unchanged files will be split differently. splitmix64 is
+	// used to generate it deterministically.
+	seed := uint64(0x6f637479_6e65)
+	for i := range gearTable {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gearTable[i] = z ^ (z >> 31)
+	}
+}
+
+// splitChunks splits the contents of r into chunks, calling fn with each chunk in order.
+// The chunk passed to fn is only valid until fn returns.
+func splitChunks(r io.Reader, fn func(chunk []byte) error) error {
+	reader := bufio.NewReaderSize(r, maxChunkSize)
+	buffer := make([]byte, maxChunkSize)
+	for {
+		length := 0
+		hash := uint64(0)
+		var err error
+		for length < maxChunkSize {
+			var b byte
+			b, err = reader.ReadByte()
+			if err != nil {
+				break
+			}
+			buffer[length] = b
+			length++
+			hash = (hash << 1) + gearTable[b]
+			if length >= minChunkSize && hash&chunkHashMask == 0 {
+				break
+			}
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		} else if length > 0 {
+			if err := fn(buffer[:length]); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// chunkHashes splits data into chunks, checking their sizes, and returns their hashes.
+func chunkHashes(t *testing.T, data []byte) [][sha256.Size]byte {
+	var hashes [][sha256.Size]byte
+	var joined []byte
+	err := splitChunks(bytes.NewReader(data), func(chunk []byte) error {
+		if len(chunk) > maxChunkSize || (len(chunk) < minChunkSize && len(joined)+len(chunk) < len(data)) {
+			t.Errorf("chunk of invalid size %d", len(chunk))
+		}
+		joined = append(joined, chunk...)
+		hashes = append(hashes, sha256.Sum256(chunk))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(joined, data) {
+		t.Fatal("chunks don't add up to the original data")
+	}
+	return hashes
+}
+
+func TestSplitChunksInsert(t *testing.T) {
+	data := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	original := chunkHashes(t, data)
+	if len(original) < 8 {
+		t.Fatalf("expected at least 8 chunks, got %d", len(original))
+	}
+
+	// Inserting data should only change the chunks surrounding the insertion.
+	edited := append(slices.Clone(data[:len(data)/2]), []byte("inserted data")...)
+	edited = append(edited, data[len(data)/2:]...)
+	changed := chunkHashes(t, edited)
+	unchanged := make(map[[sha256.Size]byte]bool)
+	for _, hash := range original {
+		unchanged[hash] = true
+	}
+	shared := 0
+	for _, hash := range changed {
+		if unchanged[hash] {
+			shared++
+		}
+	}
+	if shared < len(original)-2 {
+		t.Errorf("expected at most 2 of %d chunks to change, but only %d are shared", len(original), shared)
+	}
+}
+
+func TestSplitChunksEmpty(t *testing.T) {
+	if hashes := chunkHashes(t, nil); len(hashes) != 0 {
+		t.Errorf("expected no chunks for empty data, got %d", len(hashes))
+	}
+}
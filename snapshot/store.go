@@ -0,0 +1,505 @@
+package snapshot
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+// ErrSnapshotNotFound is returned when a snapshot does not exist in the store.
+var ErrSnapshotNotFound = errors.New("no snapshot with this ID was found")
+
+// idLayout is the layout of snapshot IDs, which are always in UTC.
+const idLayout = "2006-01-02T15-04-05Z"
+
+// Store is a content-addressed store of file snapshots. Files are split into chunks which are
+// stored once, no matter how many snapshots or servers they are a part of.
+//
+// Chunks are stored in chunks/ab/abcdef... by their SHA-256 hash. Each snapshot is stored as a
+// summary in snapshots/<server>/<id>.json and a list of files in snapshots/<server>/<id>.files.gz,
+// where the summary is written last to mark the snapshot as complete.
+type Store struct {
+	Directory string
+	// Lock is held for reading by operations adding or reading snapshots, and for writing when
+	// deleting unused chunks, so that chunks in use by a new snapshot aren't deleted.
+	Lock sync.RWMutex
+}
+
+// Snapshot is a summary of a snapshot in the store.
+type Snapshot struct {
+	ID         string `json:"id"`
+	Time       int64  `json:"time"`
+	Files      int    `json:"files"`
+	Size       int64  `json:"size"`
+	AddedSize  int64  `json:"addedSize"`
+	AddedCount int    `json:"addedChunks"`
+}
+
+// File is a file, folder or symlink in a snapshot.
+type File struct {
+	Path    string      `json:"path"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime int64       `json:"modTime"`
+	Size    int64       `json:"size,omitempty"`
+	Link    string      `json:"link,omitempty"`
+	Chunks  []string    `json:"chunks,omitempty"`
+}
+
+var stores = xsync.NewMapOf[string, *Store]()
+
+// Open returns the store located in a directory, creating it if necessary.
+// The same Store is returned for all calls with the same directory.
+func Open(dir string) (*Store, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	} else if err = os.MkdirAll(filepath.Join(dir, "chunks"), os.ModePerm); err != nil {
+		return nil, err
+	} else if err = os.MkdirAll(filepath.Join(dir, "snapshots"), os.ModePerm); err != nil {
+		return nil, err
+	}
+	store, _ := stores.LoadOrStore(dir, &Store{Directory: dir})
+	return store, nil
+}
+
+func (s *Store) serverDir(server string) string {
+	return filepath.Join(s.Directory, "snapshots", url.PathEscape(server))
+}
+
+func (s *Store) chunkPath(hash string) string {
+	return filepath.Join(s.Directory, "chunks", hash[:2], hash)
+}
+
+// writeFileAtomically writes a file to a temporary file first, then renames it over the target.
+func writeFileAtomically(name string, write func(w io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // Fails harmlessly once renamed.
+	err = write(file)
+	if err1 := file.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), name)
+}
+
+// List returns the complete snapshots of a server, sorted from newest to oldest.
+func (s *Store) List(server string) ([]Snapshot, error) {
+	snapshots := make([]Snapshot, 0)
+	entries, err := os.ReadDir(s.serverDir(server))
+	if os.IsNotExist(err) {
+		return snapshots, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		id, isSummary := strings.CutSuffix(entry.Name(), ".json")
+		if !isSummary || strings.HasPrefix(id, ".") {
+			continue
+		}
+		snapshot, err := s.Get(server, id)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	slices.SortFunc(snapshots, func(a, b Snapshot) int { return strings.Compare(b.ID, a.ID) })
+	return snapshots, nil
+}
+
+// Get returns the summary of a snapshot.
+func (s *Store) Get(server string, id string) (Snapshot, error) {
+	var snapshot Snapshot
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return snapshot, ErrSnapshotNotFound
+	}
+	contents, err := os.ReadFile(filepath.Join(s.serverDir(server), id+".json"))
+	if os.IsNotExist(err) {
+		return snapshot, ErrSnapshotNotFound
+	} else if err != nil {
+		return snapshot, err
+	}
+	err = json.Unmarshal(contents, &snapshot)
+	return snapshot, err
+}
+
+// Files returns the files contained in a snapshot.
+func (s *Store) Files(server string, id string) ([]File, error) {
+	if _, err := s.Get(server, id); err != nil {
+		return nil, err
+	}
+	return s.readFiles(filepath.Join(s.serverDir(server), id+".files.gz"))
+}
+
+func (*Store) readFiles(name string) ([]File, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	err = json.NewDecoder(reader).Decode(&files)
+	return files, err
+}
+
+// writeChunk stores a chunk if it doesn't exist already, and returns its hash and whether it was
+// newly added to the store.
+func (s *Store) writeChunk(chunk []byte) (string, bool, error) {
+	sum := sha256.Sum256(chunk)
+	hash := hex.EncodeToString(sum[:])
+	name := s.chunkPath(hash)
+	if _, err := os.Stat(name); err == nil {
+		return hash, false, nil
+	} else if !os.IsNotExist(err) {
+		return "", false, err
+	} else if err = os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return "", false, err
+	}
+	err := writeFileAtomically(name, func(w io.Writer) error {
+		_, err := w.Write(chunk)
+		return err
+	})
+	return hash, err == nil, err
+}
+
+// Create creates a snapshot of a server, containing the given slash-separated paths in root.
+// Files and folders for which filter returns false are skipped. Files whose size, mode and
+// modification time are unchanged since the server's last snapshot are not read again.
+func (s *Store) Create(server string, root string, paths []string, filter func(string) bool) (Snapshot, error) {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+	snapshot := Snapshot{}
+
+	// Get the files in the previous snapshot.
+	previous := make(map[string]File)
+	if snapshots, err := s.List(server); err != nil {
+		return snapshot, err
+	} else if len(snapshots) > 0 {
+		files, err := s.Files(server, snapshots[0].ID)
+		if err != nil {
+			return snapshot, err
+		}
+		for _, file := range files {
+			previous[file.Path] = file
+		}
+	}
+
+	// Add all the files to the snapshot.
+	files := make([]File, 0)
+	added := make(map[string]bool)
+	for _, subpath := range paths {
+		start := filepath.Join(root, filepath.FromSlash(subpath))
+		err := filepath.WalkDir(start, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if name == start && os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			rel, err := filepath.Rel(root, name)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if added[rel] {
+				return nil
+			} else if filter != nil && !filter(rel) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			added[rel] = true
+			file := File{Path: rel, Mode: info.Mode(), ModTime: info.ModTime().UnixNano()}
+			if info.Mode()&os.ModeSymlink != 0 {
+				file.Link, err = os.Readlink(name)
+			} else if info.Mode().IsRegular() {
+				file.Size = info.Size()
+				snapshot.Size += file.Size
+				if prev, ok := previous[rel]; ok && prev.Mode == file.Mode &&
+					prev.ModTime == file.ModTime && prev.Size == file.Size {
+					file.Chunks = prev.Chunks
+				} else {
+					err = s.addFile(name, &file, &snapshot)
+				}
+			} else if !info.IsDir() {
+				return nil // Skip sockets, devices, etc.
+			}
+			files = append(files, file)
+			return err
+		})
+		if err != nil {
+			return snapshot, err
+		}
+	}
+	snapshot.Files = len(files)
+
+	// Write the snapshot.
+	dir := s.serverDir(server)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return snapshot, err
+	}
+	created := time.Now().UTC()
+	snapshot.Time = created.Unix()
+	snapshot.ID = created.Format(idLayout)
+	for suffix := 1; ; suffix++ {
+		if _, err := os.Stat(filepath.Join(dir, snapshot.ID+".json")); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return snapshot, err
+		}
+		snapshot.ID = created.Format(idLayout) + "-" + fmt.Sprint(suffix)
+	}
+	err := writeFileAtomically(filepath.Join(dir, snapshot.ID+".files.gz"), func(w io.Writer) error {
+		writer := gzip.NewWriter(w)
+		if err := json.NewEncoder(writer).Encode(files); err != nil {
+			return err
+		}
+		return writer.Close()
+	})
+	if err != nil {
+		return snapshot, err
+	}
+	err = writeFileAtomically(filepath.Join(dir, snapshot.ID+".json"), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(snapshot)
+	})
+	return snapshot, err
+}
+
+func (s *Store) addFile(name string, file *File, snapshot *Snapshot) error {
+	reader, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return splitChunks(reader, func(chunk []byte) error {
+		hash, isNew, err := s.writeChunk(chunk)
+		if err != nil {
+			return err
+		} else if isNew {
+			snapshot.AddedCount++
+			snapshot.AddedSize += int64(len(chunk))
+		}
+		file.Chunks = append(file.Chunks, hash)
+		return nil
+	})
+}
+
+// readChunk reads a chunk from the store, verifying that its contents match its hash.
+func (s *Store) readChunk(hash string) ([]byte, error) {
+	if len(hash) != sha256.Size*2 {
+		return nil, errors.New("invalid chunk hash " + hash)
+	}
+	chunk, err := os.ReadFile(s.chunkPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(chunk)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, errors.New("chunk " + hash + " is corrupt")
+	}
+	return chunk, nil
+}
+
+// Restore writes the files in a snapshot of a server to root.
+func (s *Store) Restore(server string, id string, root string) error {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+	files, err := s.Files(server, id)
+	if err != nil {
+		return err
+	}
+	dirs := make([]File, 0)
+	for _, file := range files {
+		name := filepath.Join(root, filepath.FromSlash(path.Clean("/"+file.Path)))
+		if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+			return err
+		}
+		if file.Mode.IsDir() {
+			if err := os.MkdirAll(name, os.ModePerm); err != nil {
+				return err
+			}
+			dirs = append(dirs, file)
+			continue
+		} else if file.Mode&os.ModeSymlink != 0 {
+			if err := os.Symlink(file.Link, name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.restoreFile(name, file); err != nil {
+			return err
+		}
+	}
+	// Set folder permissions last, since they may not be writable.
+	for index := len(dirs) - 1; index >= 0; index-- {
+		name := filepath.Join(root, filepath.FromSlash(path.Clean("/"+dirs[index].Path)))
+		if err := os.Chmod(name, dirs[index].Mode.Perm()); err != nil {
+			return err
+		}
+		modTime := time.Unix(0, dirs[index].ModTime)
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) restoreFile(name string, file File) error {
+	writer, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+	for _, hash := range file.Chunks {
+		chunk, err := s.readChunk(hash)
+		if err != nil {
+			return err
+		} else if _, err = writer.Write(chunk); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	} else if err = os.Chmod(name, file.Mode.Perm()); err != nil {
+		return err
+	}
+	modTime := time.Unix(0, file.ModTime)
+	return os.Chtimes(name, modTime, modTime)
+}
+
+// Verify checks that all chunks used by a snapshot exist and are not corrupt, returning a list of
+// problems found with the snapshot.
+func (s *Store) Verify(server string, id string) ([]string, error) {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+	files, err := s.Files(server, id)
+	if err != nil {
+		return nil, err
+	}
+	problems := make([]string, 0)
+	verified := make(map[string]bool)
+	for _, file := range files {
+		for _, hash := range file.Chunks {
+			if verified[hash] {
+				continue
+			}
+			verified[hash] = true
+			if _, err := s.readChunk(hash); os.IsNotExist(err) {
+				problems = append(problems, file.Path+": chunk "+hash+" is missing")
+			} else if err != nil {
+				problems = append(problems, file.Path+": "+err.Error())
+			}
+		}
+	}
+	return problems, nil
+}
+
+// Delete deletes snapshots of a server. The chunks used by them are only deleted by GC.
+func (s *Store) Delete(server string, ids ...string) error {
+	for _, id := range ids {
+		if _, err := s.Get(server, id); err != nil {
+			return err
+		}
+		// Delete the summary first, so incomplete snapshots are never listed.
+		err := os.Remove(filepath.Join(s.serverDir(server), id+".json"))
+		if err != nil {
+			return err
+		}
+		err = os.Remove(filepath.Join(s.serverDir(server), id+".files.gz"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// GC deletes chunks which are not used by any snapshot of any server, along with incomplete
+// snapshots. It returns the number of chunks deleted and the space freed in bytes.
+func (s *Store) GC() (int, int64, error) {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	// Find all chunks in use, deleting incomplete snapshots.
+	used := make(map[string]bool)
+	servers, err := os.ReadDir(filepath.Join(s.Directory, "snapshots"))
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, server := range servers {
+		dir := filepath.Join(s.Directory, "snapshots", server.Name())
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, entry := range entries {
+			id, isFileList := strings.CutSuffix(entry.Name(), ".files.gz")
+			if !isFileList {
+				if strings.HasPrefix(entry.Name(), ".tmp-") {
+					os.Remove(filepath.Join(dir, entry.Name()))
+				}
+				continue
+			} else if _, err := os.Stat(filepath.Join(dir, id+".json")); os.IsNotExist(err) {
+				os.Remove(filepath.Join(dir, entry.Name()))
+				continue
+			}
+			files, err := s.readFiles(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return 0, 0, err
+			}
+			for _, file := range files {
+				for _, hash := range file.Chunks {
+					used[hash] = true
+				}
+			}
+		}
+	}
+
+	// Delete unused chunks.
+	deleted := 0
+	freed := int64(0)
+	err = filepath.WalkDir(filepath.Join(s.Directory, "chunks"), func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || used[entry.Name()] {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		} else if err = os.Remove(name); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(entry.Name(), ".tmp-") {
+			deleted++
+			freed += info.Size()
+		}
+		return nil
+	})
+	return deleted, freed, err
+}
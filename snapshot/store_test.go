@@ -0,0 +1,213 @@
+package snapshot
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestFiles writes files to root, with each file's path mapped to its contents.
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	for name, contents := range files {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+			t.Fatal(err)
+		} else if err = os.WriteFile(name, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkTestFiles checks that the files in root have the given contents.
+func checkTestFiles(t *testing.T, root string, files map[string]string) {
+	for name, contents := range files {
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+		} else if string(data) != contents {
+			t.Errorf("%s has %d bytes of unexpected contents", name, len(data))
+		}
+	}
+}
+
+// randomString returns n bytes of random data, which is split into multiple chunks if large.
+func randomString(seed int64, n int) string {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return string(data)
+}
+
+func TestCreateRestore(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	files := map[string]string{
+		"server.properties":    "motd=hi\n",
+		"world/level.dat":      randomString(1, 3<<20),
+		"world/region/r.mca":   randomString(2, 100),
+		"logs/latest.log":      "skipped",
+		"plugins/empty.yml":    "",
+		"plugins/a/config.yml": "a: b\n",
+	}
+	writeTestFiles(t, root, files)
+	if err := os.Symlink("world", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	filter := func(path string) bool { return path != "logs" }
+	snapshot, err := store.Create("test", root, []string{".", "missing"}, filter)
+	if err != nil {
+		t.Fatal(err)
+	} else if snapshot.AddedCount < 5 || snapshot.Files == 0 {
+		t.Errorf("unexpected snapshot summary: %+v", snapshot)
+	}
+	if snapshots, err := store.List("test"); err != nil || len(snapshots) != 1 || snapshots[0] != snapshot {
+		t.Errorf("unexpected snapshots: %+v, %v", snapshots, err)
+	}
+
+	restored := t.TempDir()
+	if err := store.Restore("test", snapshot.ID, restored); err != nil {
+		t.Fatal(err)
+	}
+	delete(files, "logs/latest.log")
+	checkTestFiles(t, restored, files)
+	if _, err := os.Stat(filepath.Join(restored, "logs")); !os.IsNotExist(err) {
+		t.Errorf("expected filtered folder to be skipped, got %v", err)
+	}
+	if link, err := os.Readlink(filepath.Join(restored, "link")); err != nil || link != "world" {
+		t.Errorf("unexpected symlink: %q, %v", link, err)
+	}
+	if err := store.Restore("test", "missing", restored); err != ErrSnapshotNotFound {
+		t.Errorf("expected ErrSnapshotNotFound, got %v", err)
+	}
+}
+
+func TestCreateDedup(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"a.txt": randomString(1, 2<<20), "b.txt": "b"})
+	first, err := store.Create("test", root, []string{"."}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unchanged files, including ones whose modification time changed, add no chunks.
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "a.txt"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.Create("test", root, []string{"."}, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if second.AddedCount != 0 || second.AddedSize != 0 || second.Size != first.Size {
+		t.Errorf("expected no chunks to be added, got %+v", second)
+	} else if second.ID == first.ID {
+		t.Errorf("expected snapshots created together to have different IDs, got %s", second.ID)
+	}
+
+	// The same file in another server's snapshot adds no chunks.
+	other, err := store.Create("other", root, []string{"a.txt"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if other.AddedCount != 0 {
+		t.Errorf("expected no chunks to be added for another server, got %+v", other)
+	}
+
+	// Only the chunks of a changed file around the change are added.
+	writeTestFiles(t, root, map[string]string{"a.txt": randomString(1, 2<<20) + "appended"})
+	third, err := store.Create("test", root, []string{"."}, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if third.AddedCount == 0 || third.AddedSize >= 2<<20 {
+		t.Errorf("expected only the last chunk to be added, got %+v", third)
+	}
+}
+
+func TestDeleteGC(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"a.txt": "old", "b.txt": "kept"})
+	first, err := store.Create("test", root, []string{"."}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, root, map[string]string{"a.txt": "new"})
+	second, err := store.Create("test", root, []string{"."}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is deleted while all chunks are in use.
+	if deleted, freed, err := store.GC(); err != nil || deleted != 0 || freed != 0 {
+		t.Errorf("unexpected GC result: %d, %d, %v", deleted, freed, err)
+	}
+	if err := store.Delete("test", first.ID); err != nil {
+		t.Fatal(err)
+	} else if err := store.Delete("test", first.ID); err != ErrSnapshotNotFound {
+		t.Errorf("expected ErrSnapshotNotFound, got %v", err)
+	}
+	if deleted, freed, err := store.GC(); err != nil || deleted != 1 || freed != int64(len("old")) {
+		t.Errorf("expected the chunk only used by the deleted snapshot to be deleted, got %d, %d, %v",
+			deleted, freed, err)
+	}
+	if problems, err := store.Verify("test", second.ID); err != nil || len(problems) != 0 {
+		t.Errorf("unexpected problems after GC: %v, %v", problems, err)
+	}
+	restored := t.TempDir()
+	if err := store.Restore("test", second.ID, restored); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFiles(t, restored, map[string]string{"a.txt": "new", "b.txt": "kept"})
+}
+
+func TestVerify(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"a.txt": "contents", "b.txt": "missing"})
+	snapshot, err := store.Create("test", root, []string{"."}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := store.Verify("test", snapshot.ID); err != nil || len(problems) != 0 {
+		t.Errorf("unexpected problems: %v, %v", problems, err)
+	}
+
+	files, err := store.Files("test", snapshot.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if file.Path == "a.txt" {
+			err = os.WriteFile(store.chunkPath(file.Chunks[0]), []byte("corrupted"), 0o644)
+		} else if file.Path == "b.txt" {
+			err = os.Remove(store.chunkPath(file.Chunks[0]))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	problems, err := store.Verify("test", snapshot.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(problems) != 2 || !strings.HasPrefix(problems[0], "a.txt: ") ||
+		!strings.Contains(problems[0], "corrupt") || !strings.HasPrefix(problems[1], "b.txt: ") ||
+		!strings.Contains(problems[1], "missing") {
+		t.Errorf("unexpected problems: %q", problems)
+	}
+	if err := store.Restore("test", snapshot.ID, t.TempDir()); err == nil {
+		t.Error("expected restoring a corrupted snapshot to fail")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/retrixe/octyne/snapshot"
+)
+
+var errSnapshotsDisabled = errors.New("snapshots are not configured for this server")
+
+// getSnapshotStore returns the snapshot config of the server along with its snapshot store,
+// ensuring that the store is located outside the server directory.
+func (process *Process) getSnapshotStore() (SnapshotConfig, *snapshot.Store, error) {
+	process.ServerConfigMutex.RLock()
+	defer process.ServerConfigMutex.RUnlock()
+	if process.Snapshots == nil || process.Snapshots.Store == "" {
+		return SnapshotConfig{}, nil, errSnapshotsDisabled
+	}
+	config := *process.Snapshots
+	dir, err := filepath.Abs(config.Store)
+	if err != nil {
+		return config, nil, err
+	}
+	serverDir, err := filepath.Abs(process.Directory)
+	if err != nil {
+		return config, nil, err
+	} else if filepathHasPrefix(dir, serverDir) || filepathHasPrefix(serverDir, dir) {
+		return config, nil, errors.New("the snapshot store must be outside the server directory")
+	}
+	store, err := snapshot.Open(dir)
+	return config, store, err
+}
+
+// CreateSnapshot creates a snapshot of the server, then deletes old snapshots according to the
+// retention rules of the server's snapshot config.
+func (process *Process) CreateSnapshot() (snapshot.Snapshot, error) {
+	config, store, err := process.getSnapshotStore()
+	if err != nil {
+		return snapshot.Snapshot{}, err
+	} else if _, loaded := backupsInProgress.LoadOrStore(process.Name, true); loaded {
+		return snapshot.Snapshot{}, errBackupInProgress
+	}
+	defer backupsInProgress.Delete(process.Name)
+
+	process.SendConsoleOutput("[Octyne] Creating snapshot of server " + process.Name)
+	created, err := (func() (snapshot.Snapshot, error) {
+		defer process.sendBackupCommands(config.BackupJobConfig)()
+		process.ServerConfigMutex.RLock()
+		serverDir := process.Directory
+		process.ServerConfigMutex.RUnlock()
+		return store.Create(process.Name, serverDir, config.backupPaths(), config.excludeFilter())
+	})()
+	if err != nil {
+		process.SendConsoleOutput("[Octyne] Failed to create snapshot of server " + process.Name)
+		return created, err
+	}
+	process.SendConsoleOutput("[Octyne] Created snapshot " + created.ID + " of server " + process.Name)
+
+	deleted, err := process.pruneSnapshots(config, store)
+	if err == nil && deleted > 0 {
+		_, _, err = store.GC()
+	}
+	return created, err
+}
+
+// PruneSnapshots deletes old snapshots of the server according to the retention rules of the
+// server's snapshot config, then deletes unused chunks from the snapshot store.
+// It returns the number of snapshots and chunks deleted, and the space freed in bytes.
+func (process *Process) PruneSnapshots() (int, int, int64, error) {
+	config, store, err := process.getSnapshotStore()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	deleted, err := process.pruneSnapshots(config, store)
+	if err != nil {
+		return deleted, 0, 0, err
+	}
+	chunks, freed, err := store.GC()
+	return deleted, chunks, freed, err
+}
+
+// pruneSnapshots deletes old snapshots of the server and returns the number of snapshots deleted.
+// Unused chunks are not deleted from the store.
+func (process *Process) pruneSnapshots(config SnapshotConfig, store *snapshot.Store) (int, error) {
+	snapshots, err := store.List(process.Name)
+	if err != nil {
+		return 0, err
+	}
+	times := make([]time.Time, len(snapshots))
+	for index, snapshot := range snapshots {
+		times[index] = time.Unix(snapshot.Time, 0)
+	}
+	ids := make([]string, 0)
+	for _, index := range config.Retention.expired(times) {
+		ids = append(ids, snapshots[index].ID)
+	}
+	return len(ids), store.Delete(process.Name, ids...)
+}
+
+// RestoreSnapshot replaces the files in the server directory included in the server's snapshot
+// config with the contents of a snapshot. The server must not be running.
+func (process *Process) RestoreSnapshot(id string) error {
+	config, store, err := process.getSnapshotStore()
+	if err != nil {
+		return err
+	} else if _, err = store.Get(process.Name, id); err != nil {
+		return err
+	} else if process.Online.Load() == 1 {
+		return errServerRunning
+	} else if _, loaded := backupsInProgress.LoadOrStore(process.Name, true); loaded {
+		return errBackupInProgress
+	}
+	defer backupsInProgress.Delete(process.Name)
+	process.ServerConfigMutex.RLock()
+	serverDir := process.Directory
+	process.ServerConfigMutex.RUnlock()
+	err = replaceServerFiles(serverDir, config.backupPaths(), config.excludeFilter(), func() error {
+		return store.Restore(process.Name, id, serverDir)
+	})
+	if err == nil {
+		process.SendConsoleOutput("[Octyne] Restored snapshot " + id + " of server " + process.Name)
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// inputQueueSize is the number of lines of input which can be queued for each server.
+const inputQueueSize = 100
+
+// controlQueueSize is the number of control commands sent by Octyne itself, e.g. stop commands and
+// backup commands, which can be queued for each server. Control commands are queued separately and
+// written before other input, so they aren't dropped or delayed when the input queue is full.
+const controlQueueSize = 20
+
+// defaultWriteTimeout is how long input can wait to be written to the stdin of a server by default.
+const defaultWriteTimeout = 5 * time.Second
+
+var (
+	errInputQueueFull   = errors.New("input queue of the server is full")
+	errInputTimeout     = errors.New("input was not written to the server in time")
+	errServerNotRunning = errors.New("server is not running")
+)
+
+// stdinWrite is a line of input waiting to be written to the stdin of a server.
+type stdinWrite struct {
+	data     []byte
+	deadline time.Time
+	result   chan error // Buffered, receives the result of the write.
+}
+
+// writeTimeout returns how long input can wait to be written to the stdin of the server.
+func (process *Process) writeTimeout() time.Duration {
+	process.ServerConfigMutex.RLock()
+	defer process.ServerConfigMutex.RUnlock()
+	timeout, err := time.ParseDuration(process.Console.Input.WriteTimeout)
+	if err != nil || timeout <= 0 {
+		return defaultWriteTimeout
+	}
+	return timeout
+}
+
+// QueueInput queues a line of input to be written to the stdin of the server, and returns a
+// channel which receives the result of the write. If the queue is full, an error is returned.
+func (process *Process) QueueInput(command string) (<-chan error, error) {
+	return process.queueInput(process.InputQueue, command)
+}
+
+// QueueControlInput queues a control command to be written to the stdin of the server before any
+// other queued input, like QueueInput.
+func (process *Process) QueueControlInput(command string) (<-chan error, error) {
+	return process.queueInput(process.ControlQueue, command)
+}
+
+func (process *Process) queueInput(queue chan stdinWrite, command string) (<-chan error, error) {
+	write := stdinWrite{
+		data:     []byte(command + "\n"),
+		deadline: time.Now().Add(process.writeTimeout()),
+		result:   make(chan error, 1),
+	}
+	select {
+	case queue <- write:
+		return write.result, nil
+	default:
+		return nil, errInputQueueFull
+	}
+}
+
+// SendCommand sends an input to stdin of the process, and waits until it is written. Input which
+// can't be written before the write timeout of the server is dropped.
+func (process *Process) SendCommand(command string) error {
+	return process.waitForWrite(process.QueueInput(command))
+}
+
+// SendControlCommand sends a control command to stdin of the process before any other queued
+// input, and waits until it is written, like SendCommand.
+func (process *Process) SendControlCommand(command string) error {
+	return process.waitForWrite(process.QueueControlInput(command))
+}
+
+func (process *Process) waitForWrite(result <-chan error, err error) error {
+	if err != nil {
+		return err
+	}
+	// Writes may not time out on platforms without support for pipe deadlines, e.g. Windows.
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(process.writeTimeout() + time.Second):
+		return errInputTimeout
+	}
+}
+
+// writeInput writes queued input to the stdin of the server in order, until the server is removed.
+// Queued control commands are always written first.
+func (process *Process) writeInput() {
+	for {
+		select {
+		case write := <-process.ControlQueue:
+			write.result <- process.writeStdin(write)
+			continue
+		default:
+		}
+		select {
+		case write := <-process.ControlQueue:
+			write.result <- process.writeStdin(write)
+		case write := <-process.InputQueue:
+			write.result <- process.writeStdin(write)
+		case <-process.InputClosed:
+			return
+		}
+	}
+}
+
+// writeStdin writes a line of input to the stdin of the server, unless its deadline has passed.
+// CommandMutex isn't held during the write, so a server which isn't reading its stdin doesn't
+// block the server from being started or stopped.
+func (process *Process) writeStdin(write stdinWrite) error {
+	process.CommandMutex.RLock()
+	stdin := process.Stdin
+	process.CommandMutex.RUnlock()
+	if stdin == nil {
+		return errServerNotRunning
+	} else if time.Now().After(write.deadline) {
+		return errInputTimeout
+	}
+	stdin.SetWriteDeadline(write.deadline) // Unsupported on some platforms: skipcq GSC-G104
+	_, err := stdin.Write(write.data)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return errInputTimeout
+	} else if errors.Is(err, os.ErrClosed) || errors.Is(err, syscall.EPIPE) {
+		return errServerNotRunning
+	}
+	return err
+}
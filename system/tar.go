@@ -8,6 +8,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -36,8 +37,11 @@ func ExtractTarFile(tarFile string, location string) error {
 		reader = NativeCompressionReader(file, "zstd")
 	}
 
-	// Extract archive.
-	archive := tar.NewReader(reader)
+	return ExtractTar(tar.NewReader(reader), location)
+}
+
+// ExtractTar extracts the remaining files in a tar.Reader to a location.
+func ExtractTar(archive *tar.Reader, location string) error {
 	for {
 		header, err := archive.Next()
 		if errors.Is(err, io.EOF) {
@@ -79,6 +83,15 @@ func ExtractTarFile(tarFile string, location string) error {
 
 // AddFileToTar adds a file/folder to a tar.Writer.
 func AddFileToTar(archive *tar.Writer, dir string, file string) error {
+	return AddFilteredFileToTar(archive, dir, file, nil)
+}
+
+// AddFilteredFileToTar adds a file/folder to a tar.Writer, skipping files and folders for which
+// filter returns false. The filter is passed slash-separated paths relative to dir.
+func AddFilteredFileToTar(archive *tar.Writer, dir string, file string, filter func(string) bool) error {
+	if filter != nil && !filter(file) {
+		return nil
+	}
 	fileToTar, err := os.Open(joinPath(dir, file))
 	if err != nil {
 		return err
@@ -96,7 +109,7 @@ func AddFileToTar(archive *tar.Writer, dir string, file string) error {
 			return err
 		}
 		for _, child := range files {
-			err = AddFileToTar(archive, dir, joinPath(file, child.Name()))
+			err = AddFilteredFileToTar(archive, dir, path.Join(file, child.Name()), filter)
 			if err != nil {
 				return err
 			}
@@ -140,7 +153,8 @@ func NativeCompressionReader(r io.Reader, algorithm string) io.ReadCloser {
 }
 
 // NativeCompressionWriter can use xz/zstd installed in your system PATH for compression.
-// It uses zstd long distance mode for better compression.
+// It uses zstd long distance mode for better compression. Closing the returned writer waits for
+// the compression process to finish writing to w.
 func NativeCompressionWriter(w io.Writer, algorithm string) io.WriteCloser {
 	rpipe, wpipe := io.Pipe()
 	cmd := exec.Command(algorithm, "--stdout")
@@ -149,9 +163,23 @@ func NativeCompressionWriter(w io.Writer, algorithm string) io.WriteCloser {
 	}
 	cmd.Stdin = rpipe
 	cmd.Stdout = w
+	writer := &nativeCompressionWriter{PipeWriter: wpipe, done: make(chan struct{})}
 	go func() {
-		err := cmd.Run()
-		wpipe.CloseWithError(err)
+		writer.err = cmd.Run()
+		wpipe.CloseWithError(writer.err)
+		close(writer.done)
 	}()
-	return wpipe
+	return writer
+}
+
+type nativeCompressionWriter struct {
+	*io.PipeWriter
+	done chan struct{}
+	err  error
+}
+
+func (w *nativeCompressionWriter) Close() error {
+	w.PipeWriter.Close()
+	<-w.done
+	return w.err
 }
@@ -60,6 +60,17 @@ func UnzipFile(zipFile string, location string) error {
 
 // AddFileToZip adds a file or folder to a zip.Writer using Deflate.
 func AddFileToZip(archive *zip.Writer, dir string, file string, compress bool) error {
+	return AddFilteredFileToZip(archive, dir, file, compress, nil)
+}
+
+// AddFilteredFileToZip adds a file or folder to a zip.Writer, skipping files and folders for which
+// filter returns false. The filter is passed slash-separated paths relative to dir.
+func AddFilteredFileToZip(
+	archive *zip.Writer, dir string, file string, compress bool, filter func(string) bool,
+) error {
+	if filter != nil && !filter(file) {
+		return nil
+	}
 	fileToZip, err := os.Open(joinPath(dir, file))
 	if err != nil {
 		return err
@@ -76,7 +87,7 @@ func AddFileToZip(archive *zip.Writer, dir string, file string, compress bool) e
 			return err
 		}
 		for _, child := range files {
-			err = AddFileToZip(archive, dir, joinPath(file, child.Name()), compress)
+			err = AddFilteredFileToZip(archive, dir, path.Join(file, child.Name()), compress, filter)
 			if err != nil {
 				return err
 			}
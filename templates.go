@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/retrixe/octyne/system"
+)
+
+var errTemplateNotFound = errors.New("this template does not exist")
+var errMissingTemplateVariable = errors.New("a required template variable is missing")
+var errInvalidTemplateConfig = errors.New("the config is not valid JSON after substituting variables")
+
+// templateManifestFile is the name of the manifest inside each template folder.
+const templateManifestFile = "template.json"
+
+// templateVariableRegex matches variables in templates, e.g. {{port}}.
+var templateVariableRegex = regexp.MustCompile(`{{\s*([A-Za-z0-9_.-]+)\s*}}`)
+
+// TemplateVariable is a variable which is substituted when creating a server from a template.
+type TemplateVariable struct {
+	Description string `json:"description,omitempty"`
+	Default     string `json:"default,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+// Template is the manifest of a template folder, describing how to create a server from it.
+type Template struct {
+	Description  string                      `json:"description,omitempty"`
+	Files        []string                    `json:"files,omitempty"`
+	Substitute   []string                    `json:"substitute,omitempty"`
+	Variables    map[string]TemplateVariable `json:"variables,omitempty"`
+	Command      string                      `json:"command"`
+	StopCommands []string                    `json:"stopCommands,omitempty"`
+	Config       map[string]json.RawMessage  `json:"config,omitempty"`
+}
+
+// readTemplate reads the manifest of the template with the given name.
+func readTemplate(dir string, name string) (Template, error) {
+	var template Template
+	if !isValidServerName(name) {
+		return template, errTemplateNotFound
+	}
+	contents, err := os.ReadFile(filepath.Join(dir, name, templateManifestFile))
+	if os.IsNotExist(err) {
+		return template, errTemplateNotFound
+	} else if err != nil {
+		return template, err
+	}
+	err = json.Unmarshal(contents, &template)
+	return template, err
+}
+
+// listTemplates reads the manifests of all templates in a folder. Invalid templates are skipped.
+func listTemplates(dir string) (map[string]Template, error) {
+	templates := make(map[string]Template)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return templates, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		template, err := readTemplate(dir, entry.Name())
+		if err != nil && !errors.Is(err, errTemplateNotFound) {
+			log.Println("Failed to read template "+entry.Name()+"!", err)
+		} else if err == nil {
+			templates[entry.Name()] = template
+		}
+	}
+	return templates, nil
+}
+
+// substituteVariables replaces variables in text with their values. Unknown variables are kept.
+func substituteVariables(text string, variables map[string]string) string {
+	return templateVariableRegex.ReplaceAllStringFunc(text, func(match string) string {
+		if value, ok := variables[templateVariableRegex.FindStringSubmatch(match)[1]]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+// variables returns the values of all variables in the template, using defaults for missing ones.
+// The server name and directory are available as the name and directory variables.
+func (t *Template) variables(name string, directory string, values map[string]string) (map[string]string, error) {
+	variables := make(map[string]string)
+	for key, variable := range t.Variables {
+		if value, ok := values[key]; ok {
+			variables[key] = value
+		} else if variable.Required {
+			return nil, fmt.Errorf("%w: %s", errMissingTemplateVariable, key)
+		} else {
+			variables[key] = variable.Default
+		}
+	}
+	variables["name"] = name
+	variables["directory"] = directory
+	return variables, nil
+}
+
+// serverConfig returns the fields of the config of a server created from the template. If a field
+// isn't valid JSON after substituting variables, e.g. if a variable used as a number isn't one,
+// errInvalidTemplateConfig is returned.
+func (t *Template) serverConfig(directory string, variables map[string]string) (map[string]json.RawMessage, error) {
+	// Values are escaped, since variables are usually substituted inside JSON strings.
+	escaped := make(map[string]string)
+	for key, value := range variables {
+		quoted, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		escaped[key] = string(quoted[1 : len(quoted)-1])
+	}
+	fields := make(map[string]json.RawMessage)
+	for key, value := range t.Config {
+		fields[key] = json.RawMessage(substituteVariables(string(value), escaped))
+		if !json.Valid(fields[key]) {
+			return nil, fmt.Errorf("%w: %s", errInvalidTemplateConfig, key)
+		}
+	}
+	stopCommands := make([]string, 0, len(t.StopCommands))
+	for _, command := range t.StopCommands {
+		stopCommands = append(stopCommands, substituteVariables(command, variables))
+	}
+	values := map[string]interface{}{
+		"directory": directory,
+		"command":   substituteVariables(t.Command, variables),
+	}
+	if len(stopCommands) > 0 {
+		values["stopCommands"] = stopCommands
+	}
+	for key, value := range values {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = encoded
+	}
+	return fields, nil
+}
+
+// copyFiles copies the files of the template to the server directory, which must not exist yet,
+// then substitutes variables into the files listed in Substitute.
+func (t *Template) copyFiles(templateDir string, directory string, variables map[string]string) error {
+	files := t.Files
+	if len(files) == 0 {
+		entries, err := os.ReadDir(templateDir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Name() != templateManifestFile {
+				files = append(files, entry.Name())
+			}
+		}
+	}
+	err := os.MkdirAll(directory, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		source, err := resolvePath(templateDir, file)
+		if err != nil {
+			return err
+		}
+		dest, err := resolvePath(directory, file)
+		if err != nil {
+			return err
+		}
+		stat, err := os.Lstat(source)
+		if err != nil {
+			return err
+		} else if err = os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+			return err
+		} else if err = system.Copy(stat.Mode(), source, dest); err != nil {
+			return err
+		}
+	}
+	for _, file := range t.Substitute {
+		name, err := resolvePath(directory, file)
+		if err != nil {
+			return err
+		}
+		stat, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		contents, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		substituted := substituteVariables(string(contents), variables)
+		if substituted != string(contents) {
+			err = os.WriteFile(name, []byte(substituted), stat.Mode().Perm())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestTemplateServerConfig(t *testing.T) {
+	template := Template{
+		Command: "java -jar server.jar",
+		Config: map[string]json.RawMessage{
+			"motd": json.RawMessage(`"{{motd}}"`),
+			"port": json.RawMessage(`{{port}}`),
+		},
+	}
+	fields, err := template.serverConfig("/srv", map[string]string{"motd": `say "hi" \o/`, "port": "25565"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var motd string
+	if err := json.Unmarshal(fields["motd"], &motd); err != nil || motd != `say "hi" \o/` {
+		t.Errorf("unexpected motd: %s", fields["motd"])
+	} else if string(fields["port"]) != "25565" {
+		t.Errorf("unexpected port: %s", fields["port"])
+	}
+	for _, port := range []string{"abc", `1, "command": "sh"`, ""} {
+		_, err := template.serverConfig("/srv", map[string]string{"motd": "", "port": port})
+		if !errors.Is(err, errInvalidTemplateConfig) {
+			t.Errorf("expected errInvalidTemplateConfig for port %q, got %v", port, err)
+		}
+	}
+}
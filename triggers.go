@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// defaultTriggerCooldown is the default minimum time between the actions of a console trigger.
+const defaultTriggerCooldown = time.Minute
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// triggerWebhookPayload is the JSON body of requests sent to trigger webhooks.
+type triggerWebhookPayload struct {
+	Server  string `json:"server"`
+	Trigger string `json:"trigger"`
+	Line    string `json:"line"`
+	Time    int64  `json:"time"`
+}
+
+// runTriggers checks a line of console output against the console triggers of the server, and
+// runs the actions of matching triggers which aren't on cooldown.
+func (process *ExposedProcess) runTriggers(connector *Connector, line ConsoleLine) {
+	process.ServerConfigMutex.RLock()
+	triggers := process.Triggers
+	process.ServerConfigMutex.RUnlock()
+	for name, trigger := range triggers {
+		regex := compilePattern(trigger.Pattern)
+		if regex == nil || !regex.MatchString(line.Text) {
+			continue
+		}
+		cooldown, err := time.ParseDuration(trigger.Cooldown)
+		if err != nil {
+			cooldown = defaultTriggerCooldown
+		}
+		now := time.Now()
+		fired := false
+		process.TriggersFired.Compute(name, func(lastFired time.Time, _ bool) (time.Time, bool) {
+			if now.Sub(lastFired) < cooldown {
+				return lastFired, false
+			}
+			fired = true
+			return now, false
+		})
+		if fired {
+			go process.runTriggerActions(connector, name, trigger, line)
+		}
+	}
+}
+
+func (process *ExposedProcess) runTriggerActions(
+	connector *Connector, name string, trigger TriggerConfig, line ConsoleLine,
+) {
+	if trigger.Log {
+		connector.Info("server.console.trigger", "server", process.Name, "trigger", name, "line", line.Text)
+	}
+	if trigger.Webhook != "" {
+		payload, err := json.Marshal(triggerWebhookPayload{
+			Server:  process.Name,
+			Trigger: name,
+			Line:    line.Text,
+			Time:    line.Time,
+		})
+		if err == nil {
+			var res *http.Response
+			res, err = webhookClient.Post(trigger.Webhook, "application/json", bytes.NewReader(payload))
+			if err == nil {
+				res.Body.Close()
+			}
+		}
+		if err != nil {
+			log.Println("Failed to send webhook of console trigger "+name+" of server "+process.Name+"!", err)
+		}
+	}
+	if trigger.Command != "" && process.Online.Load() == 1 {
+		if err := process.SendCommand(trigger.Command); err != nil {
+			log.Println("Failed to send command of console trigger "+name+" to server "+process.Name+"!", err)
+		}
+	}
+	if trigger.Restart && process.Online.Load() == 1 {
+		info.Println("Restarting server " + process.Name + " due to console trigger " + name)
+		process.SendConsoleOutput("[Octyne] Restarting server " + process.Name + " due to console trigger " + name)
+		process.RestartProcess(connector)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+// uploadSessionTimeout is how long an upload session is kept without any chunks being uploaded.
+const uploadSessionTimeout = 24 * time.Hour
+
+// uploadTempPrefix is the prefix of the hidden temporary files uploads are written to.
+const uploadTempPrefix = ".octyne-upload-"
+
+var (
+	errUploadOffsetMismatch = errors.New("offset does not match the offset of the upload")
+	errUploadTooLarge       = errors.New("chunk exceeds the size of the upload")
+	errUploadIncomplete     = errors.New("upload is incomplete")
+	errUploadChecksum       = errors.New("checksum does not match the uploaded file")
+	errUploadClosed         = errors.New("upload has been completed or cancelled")
+)
+
+var uploadSessions = xsync.NewMapOf[string, *UploadSession]()
+
+// UploadSession is a resumable upload of a file, which is written to a hidden temporary file in
+// the folder of the file in chunks, and renamed to the file once it is complete.
+type UploadSession struct {
+	ID       string
+	Path     string // The slash-separated path relative to the server folder.
+	Size     int64
+	Offset   int64
+	Server   string
+	User     string
+	FilePath string
+	TempPath string
+	Hash     hash.Hash // SHA-256 of the chunks uploaded so far.
+	Timer    *time.Timer
+	Closed   bool
+	// Mutex guards Offset and Closed, and is only held briefly, so the status of the upload can be
+	// read and the upload can be cancelled while a chunk is written. WriteMutex is held while a
+	// chunk is written or the upload is completed, and guards Hash and the temporary file.
+	Mutex      sync.Mutex
+	WriteMutex sync.Mutex
+}
+
+type uploadSessionResponse struct {
+	ID     string `json:"id"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Offset int64  `json:"offset"`
+}
+
+// NewUploadSession creates an upload session for a file of the given size, and creates its
+// temporary file. The session is cancelled if no chunks are uploaded for uploadSessionTimeout.
+func NewUploadSession(server string, user string, relPath string, filePath string, size int64) (
+	*UploadSession, error) {
+	idBytes := make([]byte, 16)
+	rand.Read(idBytes) // Tolerate errors here, an error here is incredibly unlikely: skipcq GSC-G104
+	id := hex.EncodeToString(idBytes)
+	session := &UploadSession{
+		ID:       id,
+		Path:     relPath,
+		Size:     size,
+		Server:   server,
+		User:     user,
+		FilePath: filePath,
+		TempPath: filepath.Join(filepath.Dir(filePath), uploadTempPrefix+id),
+		Hash:     sha256.New(),
+	}
+	// The session is stored before its temporary file is created, so removeStaleUploads skips it.
+	uploadSessions.Store(id, session)
+	file, err := os.OpenFile(session.TempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		uploadSessions.Delete(id)
+		return nil, err
+	}
+	file.Close() // skipcq GSC-G104
+	session.Timer = time.AfterFunc(uploadSessionTimeout, session.Cancel)
+	return session, nil
+}
+
+// Status returns the progress of the upload.
+func (session *UploadSession) Status() uploadSessionResponse {
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+	return uploadSessionResponse{session.ID, session.Path, session.Size, session.Offset}
+}
+
+// Write appends a chunk read from reader to the upload, which must start at the current offset of
+// the upload. If the chunk is interrupted, the bytes written so far are kept, so the upload can be
+// resumed from the new offset. If the upload is cancelled while the chunk is written, the write is
+// stopped and errUploadClosed is returned.
+func (session *UploadSession) Write(offset int64, reader io.Reader) error {
+	session.WriteMutex.Lock()
+	defer session.WriteMutex.Unlock()
+	session.Mutex.Lock()
+	if session.Closed {
+		session.Mutex.Unlock()
+		return errUploadClosed
+	} else if offset != session.Offset {
+		session.Mutex.Unlock()
+		return errUploadOffsetMismatch
+	}
+	session.Timer.Reset(uploadSessionTimeout)
+	session.Mutex.Unlock()
+	file, err := os.OpenFile(session.TempPath, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	buf := make([]byte, 32*1024)
+	remaining := io.LimitReader(reader, session.Size-offset)
+	for {
+		n, readErr := remaining.Read(buf)
+		if n > 0 {
+			written, err := file.Write(buf[:n])
+			session.Hash.Write(buf[:written]) // skipcq GSC-G104
+			offset += int64(written)
+			session.Mutex.Lock()
+			closed := session.Closed
+			session.Offset = offset
+			session.Mutex.Unlock()
+			if closed {
+				return errUploadClosed
+			} else if err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			return readErr
+		}
+	}
+	// Check if the chunk has any more data than the size of the upload.
+	if offset == session.Size {
+		if n, _ := reader.Read(buf[:1]); n > 0 {
+			return errUploadTooLarge
+		}
+	}
+	return nil
+}
+
+// Complete syncs the temporary file of the upload to disk and renames it to the file, once every
+// chunk has been uploaded. If a SHA-256 checksum is given and doesn't match, the upload is cancelled.
+func (session *UploadSession) Complete(checksum string) error {
+	session.WriteMutex.Lock()
+	defer session.WriteMutex.Unlock()
+	session.Mutex.Lock()
+	closed, offset := session.Closed, session.Offset
+	session.Mutex.Unlock()
+	if closed {
+		return errUploadClosed
+	} else if offset != session.Size {
+		return errUploadIncomplete
+	}
+	if checksum != "" && !strings.EqualFold(checksum, hex.EncodeToString(session.Hash.Sum(nil))) {
+		session.Cancel()
+		return errUploadChecksum
+	}
+	file, err := os.OpenFile(session.TempPath, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	} else if err := file.Sync(); err != nil {
+		file.Close() // skipcq GSC-G104
+		return err
+	} else if err := file.Close(); err != nil {
+		return err
+	}
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+	if session.Closed { // The upload was cancelled while it was being synced.
+		return errUploadClosed
+	} else if err := os.Rename(session.TempPath, session.FilePath); err != nil {
+		return err
+	}
+	session.close()
+	return nil
+}
+
+// Cancel cancels the upload and deletes its temporary file.
+func (session *UploadSession) Cancel() {
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+	if !session.Closed {
+		session.close()
+	}
+}
+
+// close closes the upload and deletes its temporary file. Mutex must be held.
+func (session *UploadSession) close() {
+	session.Closed = true
+	session.Timer.Stop()
+	os.Remove(session.TempPath) // Fails if the upload was completed: skipcq GSC-G104
+	uploadSessions.Delete(session.ID)
+}
+
+// removeStaleUploads deletes the temporary files of uploads in a folder and its subfolders, which
+// don't belong to an upload in progress. Uploads are only kept in memory, so their temporary files
+// are left behind when Octyne is restarted, and copied when a server is cloned.
+func removeStaleUploads(directory string) {
+	filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error { // skipcq GSC-G104
+		if err != nil || entry.IsDir() || !strings.HasPrefix(entry.Name(), uploadTempPrefix) {
+			return nil // Skip folders which can't be read.
+		} else if _, ok := uploadSessions.Load(strings.TrimPrefix(entry.Name(), uploadTempPrefix)); !ok {
+			if err := os.Remove(path); err != nil {
+				log.Println("Failed to delete stale upload "+path+"!", err)
+			}
+		}
+		return nil
+	})
+}